@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/url"
+	"os"
+	"sif/models"
+	"strconv"
+)
+
+const cycloneDXVersion = "1.5"
+
+// The CycloneDX BOM. The same types are written as JSON and XML, which only
+// differ in how the dependency graph is nested. They are also used to read
+// BOMs, which may be of other versions, so the namespace is an attribute
+// rather than part of the element name.
+type cdxBom struct {
+	XMLName      xml.Name        `json:"-" xml:"bom"`
+	XMLNS        string          `json:"-" xml:"xmlns,attr"`
+	BomFormat    string          `json:"bomFormat" xml:"-"`
+	SpecVersion  string          `json:"specVersion" xml:"-"`
+	Version      int             `json:"version" xml:"version,attr"`
+	Metadata     cdxMetadata     `json:"metadata" xml:"metadata"`
+	Components   []cdxComponent  `json:"components" xml:"components>component"`
+	Dependencies []cdxDependency `json:"dependencies" xml:"-"`
+	// The XML schema nests each dependency in the one that pulls it in
+	XMLDependencies []cdxXMLDependency `json:"-" xml:"dependencies>dependency"`
+}
+
+type cdxMetadata struct {
+	Tools     cdxTools     `json:"tools" xml:"tools>tool"`
+	Component cdxComponent `json:"component" xml:"component"`
+}
+
+type cdxTool struct {
+	Name    string `json:"name" xml:"name"`
+	Version string `json:"version" xml:"version"`
+}
+
+// CycloneDX 1.5 lists tools as components in an object, which other tools
+// write rather than the plain list of earlier versions, so both are read
+type cdxTools []cdxTool
+
+func (t *cdxTools) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return json.Unmarshal(data, (*[]cdxTool)(t))
+	}
+	var tools struct {
+		Components []cdxTool `json:"components"`
+	}
+	err := json.Unmarshal(data, &tools)
+	*t = tools.Components
+	return err
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type" xml:"type,attr"`
+	BomRef     string        `json:"bom-ref" xml:"bom-ref,attr"`
+	Group      string        `json:"group,omitempty" xml:"group,omitempty"`
+	Name       string        `json:"name" xml:"name"`
+	Version    string        `json:"version,omitempty" xml:"version,omitempty"`
+	Hashes     cdxHashes     `json:"hashes,omitempty" xml:"hashes,omitempty"`
+	Purl       string        `json:"purl,omitempty" xml:"purl,omitempty"`
+	Properties cdxProperties `json:"properties,omitempty" xml:"properties,omitempty"`
+}
+
+type cdxHash struct {
+	Algorithm string `json:"alg" xml:"alg,attr"`
+	Content   string `json:"content" xml:",chardata"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name" xml:"name,attr"`
+	Value string `json:"value" xml:",chardata"`
+}
+
+// Lists that are wrapped in an element of their own in XML. encoding/xml writes
+// the wrapper even when the list is empty, so these write it themselves.
+type cdxHashes []cdxHash
+type cdxProperties []cdxProperty
+
+func (h cdxHashes) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		Hashes []cdxHash `xml:"hash"`
+	}{h}, start)
+}
+
+func (p cdxProperties) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		Properties []cdxProperty `xml:"property"`
+	}{p}, start)
+}
+
+func (h *cdxHashes) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var list struct {
+		Hashes []cdxHash `xml:"hash"`
+	}
+	err := d.DecodeElement(&list, &start)
+	*h = list.Hashes
+	return err
+}
+
+func (p *cdxProperties) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var list struct {
+		Properties []cdxProperty `xml:"property"`
+	}
+	err := d.DecodeElement(&list, &start)
+	*p = list.Properties
+	return err
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+type cdxXMLDependency struct {
+	Ref       string             `xml:"ref,attr"`
+	DependsOn []cdxXMLDependency `xml:"dependency,omitempty"`
+}
+
+// Returns the package URL for a Maven artifact
+func packageURL(dep *models.Dependency) string {
+	purl := fmt.Sprintf("pkg:maven/%s/%s@%s",
+		url.PathEscape(dep.GroupId), url.PathEscape(dep.ArtifactId), url.PathEscape(dep.Version))
+	query := url.Values{}
+	if dep.Classifier != "" {
+		query.Set("classifier", dep.Classifier)
+	}
+	if dep.Extension != "" && dep.Extension != "jar" {
+		query.Set("type", dep.Extension)
+	}
+	if len(query) > 0 {
+		purl += "?" + query.Encode()
+	}
+	return purl
+}
+
+// Returns the SHA-256 hash of the file, or an empty string if it can't be read
+func fileSHA256(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Warnf("Unable to hash %s: %s", file, err)
+		return ""
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		log.Warnf("Unable to hash %s: %s", file, err)
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func toCycloneDX(project models.Project) cdxBom {
+	entries, classpath := rankDependencies(project, TopByOwn)
+	graph := buildDependencyGraph(calculateTotalSizes(project))
+
+	projectRef := fmt.Sprintf("%s@%s", project.Name, project.Version)
+	bom := cdxBom{
+		XMLNS:       "http://cyclonedx.org/schema/bom/" + cycloneDXVersion,
+		BomFormat:   "CycloneDX",
+		SpecVersion: cycloneDXVersion,
+		Version:     1,
+		Metadata: cdxMetadata{
+			Tools: cdxTools{{Name: "sif", Version: Version}},
+			Component: cdxComponent{
+				Type:       "application",
+				BomRef:     projectRef,
+				Name:       project.Name,
+				Version:    project.Version,
+				Properties: cdxProperties{{Name: "sif:classpathSize", Value: strconv.FormatUint(classpath, 10)}},
+			},
+		},
+		Components: []cdxComponent{},
+	}
+
+	refs := map[string]string{}
+	for _, entry := range entries {
+		dep := entry.Dependency
+		component := cdxComponent{
+			Type:    "library",
+			BomRef:  packageURL(dep),
+			Group:   dep.GroupId,
+			Name:    dep.ArtifactId,
+			Version: dep.Version,
+			Purl:    packageURL(dep),
+			Properties: cdxProperties{
+				{Name: "sif:size", Value: strconv.FormatUint(dep.Size, 10)},
+				{Name: "sif:totalSize", Value: strconv.FormatUint(entry.TotalSize, 10)},
+				{Name: "sif:exclusiveSize", Value: strconv.FormatUint(entry.ExclusiveSize, 10)},
+				{Name: "sif:status", Value: string(dep.Status)},
+			},
+		}
+		// Projects of the same build aren't published, so they have no purl
+		if dep.Status == models.StatusProject {
+			component.BomRef = dep.Coordinates()
+			component.Group = ""
+			component.Version = ""
+			component.Purl = ""
+		}
+		if dep.File != "" {
+			if hash := fileSHA256(dep.File); hash != "" {
+				component.Hashes = cdxHashes{{Algorithm: "SHA-256", Content: hash}}
+			}
+		}
+		refs[artifactKey(dep)] = component.BomRef
+		bom.Components = append(bom.Components, component)
+	}
+
+	addDependency := func(ref string, children []string) {
+		dependency := cdxDependency{Ref: ref, DependsOn: []string{}}
+		xmlDependency := cdxXMLDependency{Ref: ref}
+		seen := map[string]bool{}
+		for _, child := range children {
+			if childRef, ok := refs[child]; ok && !seen[childRef] {
+				seen[childRef] = true
+				dependency.DependsOn = append(dependency.DependsOn, childRef)
+				xmlDependency.DependsOn = append(xmlDependency.DependsOn, cdxXMLDependency{Ref: childRef})
+			}
+		}
+		bom.Dependencies = append(bom.Dependencies, dependency)
+		bom.XMLDependencies = append(bom.XMLDependencies, xmlDependency)
+	}
+	addDependency(projectRef, graph.roots)
+	for _, key := range graph.order {
+		addDependency(refs[key], graph.children[key])
+	}
+	return bom
+}
+
+// Writes a CycloneDX SBOM of the project as JSON
+func printCycloneDX(project models.Project, w io.Writer) {
+	writeJSON(w, toCycloneDX(project))
+}
+
+// Writes a CycloneDX SBOM of the project as XML
+func printCycloneDXXML(project models.Project, w io.Writer) {
+	io.WriteString(w, xml.Header)
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(toCycloneDX(project)); err != nil {
+		log.Fatalf("Failed to write CycloneDX output: %s", err)
+	}
+	io.WriteString(w, "\n")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// What --sort can order the tree by
+const (
+	// The size of each dependency's own file, largest first
+	SortBySize = "size"
+	// Each dependency's total size, largest first
+	SortByTotal = "total"
+	// The coordinates of each dependency, alphabetically
+	SortByName = "name"
+)
+
+// Counts the dependencies in the analyzed tree and adds up their sizes.
+// Dependencies left off the classpath aren't counted.
+func countDependencies(deps []AnalyzedDependency) (count int, size uint64) {
+	for i := range deps {
+		if dep := deps[i].Dependency; !dep.Omitted() {
+			count++
+			size += dep.Size
+		}
+		childCount, childSize := countDependencies(*deps[i].Children)
+		count += childCount
+		size += childSize
+	}
+	return count, size
+}
+
+// Returns true if the dependency matches any of the groupId:artifactId globs
+func matchesGlobs(entry *AnalyzedDependency, globs []string) bool {
+	name := fmt.Sprintf("%s:%s", entry.Dependency.GroupId, entry.Dependency.ArtifactId)
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the dependency or anything under it matches --include
+func includes(entry *AnalyzedDependency) bool {
+	if matchesGlobs(entry, rootCtx.Include) {
+		return true
+	}
+	for i := range *entry.Children {
+		if includes(&(*entry.Children)[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies --sort, --max-depth, --include, --exclude and --min-size to the
+// analyzed tree. The entries that are kept are copied with their sizes as they
+// are, so totals still count everything under them, hidden or not. Everything
+// under a dependency that matches --include is shown along with it.
+func shapeTree(deps []AnalyzedDependency, parent *AnalyzedDependency, included bool) []AnalyzedDependency {
+	result := []AnalyzedDependency{}
+	for i := range deps {
+		entry := &deps[i]
+		switch {
+		case rootCtx.MaxDepth > 0 && entry.Depth >= rootCtx.MaxDepth:
+		case matchesGlobs(entry, rootCtx.Exclude):
+		case entry.TotalSize < rootCtx.MinSizeBytes:
+		case len(rootCtx.Include) > 0 && !included && !includes(entry):
+		default:
+			result = append(result, *entry)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		switch rootCtx.Sort {
+		case SortBySize:
+			return result[i].Dependency.Size > result[j].Dependency.Size
+		case SortByTotal:
+			return result[i].TotalSize > result[j].TotalSize
+		case SortByName:
+			return result[i].Dependency.Coordinates() < result[j].Dependency.Coordinates()
+		default:
+			return false
+		}
+	})
+
+	// Children are copied once their parent has its final place in the slice,
+	// since they point back at it
+	for i := range result {
+		entry := &result[i]
+		entry.Parent = parent
+		children := shapeTree(*entry.Children, entry, included || matchesGlobs(entry, rootCtx.Include))
+		entry.Children = &children
+	}
+	return result
+}
@@ -3,9 +3,11 @@ module sif
 go 1.16
 
 require (
-	github.com/dustin/go-humanize v1.0.0 // indirect
-	github.com/fatih/color v1.10.0 // indirect
-	github.com/mitchellh/go-homedir v1.1.0 // indirect
-	github.com/sirupsen/logrus v1.8.1 // indirect
-	github.com/spf13/cobra v1.1.3 // indirect
+	github.com/dustin/go-humanize v1.0.0
+	github.com/fatih/color v1.10.0
+	github.com/mitchellh/go-homedir v1.1.0
+	github.com/sirupsen/logrus v1.8.1
+	github.com/spf13/cobra v1.1.3
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/sys v0.0.0-20200223170610-d5e6a3e2c0ae
 )
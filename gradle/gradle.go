@@ -1,26 +1,29 @@
 package gradle
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"sif/models"
+	"sif/recording"
 	"strings"
 )
 
 var (
 	regexProjectName    = regexp.MustCompile("name: (.+)")
 	regexProjectVersion = regexp.MustCompile("version: (.+)")
-	dependencyTreeRegex = regexp.MustCompile("(\\|\\s\\s\\s)*(\\+---|\\\\---) (.+)")
-	dependencyRegex     = regexp.MustCompile("([^:\\s]+):([^:\\s]+):([^:\\s]+)$")
+	regexProjectHeader  = regexp.MustCompile("(?m)^(?:Root project|Project) '?([^'\\s]+)'?")
+	dependencyTreeRegex = regexp.MustCompile("^([|\\s]*)(\\+---|\\\\---) (.+)")
+	dependencyRegex     = regexp.MustCompile("^([^:\\s]+):([^:\\s]+)(?::([^:\\s]+))?(?: -> ([^:\\s]+))?( FAILED)?$")
+	projectDepRegex     = regexp.MustCompile("^project (:[^\\s]*)$")
+	regexSubproject     = regexp.MustCompile("Project '(:[^']+)'")
+	regexSettingInclude = regexp.MustCompile("(?m)^\\s*include\\b(.*)$")
+	regexQuoted         = regexp.MustCompile("[\"']([^\"']+)[\"']")
 )
 
 type Gradle struct {
@@ -28,7 +31,15 @@ type Gradle struct {
 	BuildGradleFile string
 	Configuration   string
 	GradleCommand   string
+	GradleCache     string
 	ChildModule     string
+	AllProjects     bool
+
+	// Options passed through to Gradle
+	InitScripts       []string
+	ProjectProperties []string
+	SystemProperties  []string
+	Offline           bool
 }
 
 func (g *Gradle) describeError(errMsg string) {
@@ -36,44 +47,73 @@ func (g *Gradle) describeError(errMsg string) {
 	log.Debugf("Unknown error:\n%s", errMsg)
 }
 
+// Parses the project name from the header Gradle prints above a dependency
+// report. The version is not part of the report, so only the name is returned.
+func (g *Gradle) parseProjectHeader(output string) string {
+	r := regexProjectHeader.FindStringSubmatch(output)
+	if r == nil {
+		return ""
+	}
+	return r[1]
+}
+
+// Returns the arguments every Gradle run starts with: the project directory
+// and any options given on the command line that Gradle should see
+func (g *Gradle) baseArgs() []string {
+	args := []string{"-p", g.projectDir()}
+	for _, script := range g.InitScripts {
+		args = append(args, "--init-script", script)
+	}
+	for _, property := range g.ProjectProperties {
+		args = append(args, fmt.Sprintf("-P%s", property))
+	}
+	for _, property := range g.SystemProperties {
+		args = append(args, fmt.Sprintf("-D%s", property))
+	}
+	if g.Offline {
+		args = append(args, "--offline")
+	}
+	return args
+}
+
+func (g *Gradle) recorder() recording.Recorder {
+	return recording.Recorder{SaveDir: g.RootCtx.SaveRawDir, ReplayDir: g.RootCtx.ReplayDir}
+}
+
 func (g *Gradle) parseProjectDetails() (string, string) {
-	cmd := exec.Command(g.GradleCommand,
-		"-p",
-		g.BuildGradleFile,
-		"properties")
-	out, err := cmd.CombinedOutput()
-	output := string(out)
-	if err != nil {
-		log.Error(err)
+	recorder := g.recorder()
+	result := recorder.Run("properties", g.GradleCommand, append(g.baseArgs(), "properties")...)
+	output := result.Stdout + result.Stderr
+	if result.Err != nil {
+		log.Error(result.Err)
 		g.describeError(output)
 	}
 
-	log.Debug(output)
 	nameResult := regexProjectName.FindStringSubmatch(output)
 	versionResult := regexProjectVersion.FindStringSubmatch(output)
 	return nameResult[1], versionResult[1]
 }
 
+// Figure out the project directory path. If we were given a file, assume it
+// was a build.gradle file and the project is in the parent directory. If it
+// is a directory, assume it is the project directory.
+func (g *Gradle) projectDir() string {
+	if f, err := os.Stat(g.BuildGradleFile); err == nil && !f.IsDir() {
+		return filepath.Dir(g.BuildGradleFile)
+	}
+	return g.BuildGradleFile
+}
+
 // Search for gradle executable to use. We will first look to see if there
 // is a gradlew/gradlew.bat file in the directory specified. If none is
 // found, use the "gradle" command
 func (g *Gradle) findGradleExecutable() string {
 	log.Debugf("Searching for gradle executable")
-	f, err := os.Stat(g.BuildGradleFile)
-	if err != nil {
+	if _, err := os.Stat(g.BuildGradleFile); err != nil {
 		log.Fatalf("Unable to read Gradle file or directory: %s", err)
 	}
 
-	// Figure out the project directory path. If we were given a file, assume it
-	// was a build.gradle file and the project is in the parent directory. If it
-	// is a directory, assume it is the project directory.
-	var projectDir string
-	if f.IsDir() {
-		projectDir = g.BuildGradleFile
-	} else {
-		projectDir = filepath.Dir(g.BuildGradleFile)
-	}
-
+	projectDir := g.projectDir()
 	files, err := ioutil.ReadDir(projectDir)
 	if err != nil {
 		log.Fatalf("Failed to read files in directory: %s", err)
@@ -94,7 +134,7 @@ func (g *Gradle) findGradleExecutable() string {
 }
 
 func (g *Gradle) parseDependency(output string) *models.Dependency {
-	dep := dependencyTreeRegex.FindStringSubmatch(output)[3]
+	dep := strings.TrimSpace(dependencyTreeRegex.FindStringSubmatch(output)[3])
 
 	// Gradle dependencies have a variable format, which is a bit complex to parse. Also,
 	// the tree is not limited to just the transitives that the build will actually use
@@ -102,79 +142,151 @@ func (g *Gradle) parseDependency(output string) *models.Dependency {
 	//
 	//	* Just a plain dependency		 :		<groupId>:<artifactId>:<version>
 	//	* Version forced-changed		 :		<groupId>:<artifactId>:<version> -> <newVersion>
+	//	* Version picked by a range		 :		<groupId>:<artifactId> -> <newVersion>
 	//	* Omitted due to previous listing:		<groupId>:<artifactId>:<version> [-> <newVersion] (*)
 	//  * Dependency constrained		 :		<groupId>:<artifactId>:<version> [-> <newVersion] (c)
+	//	* Unresolvable dependency		 :		<groupId>:<artifactId>:<version> FAILED
+	//	* Project in the same build		 :		project <path> [(*)]
 	//
-	// We want to only show unique dependencies that the build will actually use. Because
-	// of this, we can safely ignore all but the plain dependencies.
+	// We want to only show unique dependencies that the build will actually use. Omitted
+	// and constrained entries are skipped, and forced changes use the new version. Failed
+	// entries were never downloaded, so they are kept as missing. Projects have no artifact
+	// in the cache, so they are kept as nodes without a size.
+	if res := projectDepRegex.FindStringSubmatch(dep); res != nil {
+		return &models.Dependency{
+			ArtifactId: res[1],
+			Status:     models.StatusProject,
+		}
+	}
 	res := dependencyRegex.FindStringSubmatch(dep)
 	if res == nil {
 		return nil
 	}
 
-	return &models.Dependency{
+	version := res[3]
+	if res[4] != "" {
+		version = res[4]
+	}
+	if res[5] != "" {
+		return &models.Dependency{
+			GroupId:    res[1],
+			ArtifactId: res[2],
+			Version:    version,
+			Extension:  "jar",
+			Status:     models.StatusMissing,
+		}
+	}
+	return g.determineFileSize(&models.Dependency{
 		GroupId:    res[1],
 		ArtifactId: res[2],
-		Version:    res[3],
-		Size:       1,
+		Version:    version,
+		Extension:  "jar",
+		Size:       0,
+	})
+}
+
+// Looks up the artifact in the Gradle module cache. The cache stores each file
+// under a directory named after its SHA-1 hash, so we have to glob for it:
+//
+//	<cache>/<groupId>/<artifactId>/<version>/<sha1>/<artifactId>-<version>.<ext>
+func (g *Gradle) determineFileSize(dep *models.Dependency) *models.Dependency {
+	versionDir := filepath.Join(g.GradleCache, dep.GroupId, dep.ArtifactId, dep.Version)
+	pattern := filepath.Join(versionDir, "*", dep.FileName())
+	dep.Size = 0
+	dep.SearchedPaths = []string{pattern}
+
+	matches, _ := filepath.Glob(pattern)
+	if len(matches) > 0 {
+		if stats, err := os.Stat(matches[0]); err == nil {
+			dep.Size = uint64(stats.Size())
+			dep.Status = models.StatusFound
+			dep.File = matches[0]
+			return dep
+		}
 	}
+
+	poms, _ := filepath.Glob(filepath.Join(versionDir, "*", fmt.Sprintf("%s-%s.pom", dep.ArtifactId, dep.Version)))
+	if len(poms) > 0 {
+		dep.Status = models.StatusPomOnly
+	} else {
+		dep.Status = models.StatusMissing
+	}
+	return dep
+}
+
+// Matches the heading Gradle prints above the tree for the configuration
+func (g *Gradle) configurationRegex() *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf("(?m)^%s( - .+)?\r?$", regexp.QuoteMeta(g.Configuration)))
 }
 
 func (g *Gradle) parseOutputTree(output string) []models.Dependency {
-	// Remove everything except the tree output. The first line
-	// that matches this regex is where we should begin.
+	// Remove everything except the tree output. The tree starts after the
+	// configuration's heading and ends at the first blank line after it.
 	var lines = strings.Split(output, "\n")
-	var startLine = 0
-	var endLine = 0
-	var startRegex = regexp.MustCompile(fmt.Sprintf("%s - Runtime classpath of .+", g.Configuration))
-	var endRegex = regexp.MustCompile("\\(c\\) - dependency constraint")
+	var startLine = -1
+	var endLine = len(lines)
+	var startRegex = g.configurationRegex()
 	for lineNum, line := range lines {
-		if startRegex.MatchString(line) {
+		line = strings.TrimRight(line, "\r")
+		if startLine < 0 && startRegex.MatchString(line) {
 			startLine = lineNum
+		} else if startLine >= 0 && strings.TrimSpace(line) == "" {
+			endLine = lineNum
+			break
 		}
-		if endRegex.MatchString(line) {
-			endLine = lineNum - 1
-		}
+	}
+	if startLine < 0 {
+		log.Warnf("No dependency tree for the %s configuration found in Gradle output", g.Configuration)
+		return nil
 	}
 
 	// The dependency tree output is in ordered form, so extracting is easy. We
 	// keep track of each top-level dep and for each child entry, we just find
 	// the last entry in the toplevel and walk down its children, using the
 	// last entry in each one until we reach the depth indicated.
-	depTreeEntries := lines[startLine:endLine]
+	depTreeEntries := lines[startLine+1 : endLine]
 	var dependencies []models.Dependency
+	var skipDepth = -1
+	var seen = map[string]bool{}
 	for _, entry := range depTreeEntries {
 		// Determine depth of the current line. dependencies has a specific
 		// format it uses to indicate parent-child relationships:
 		//
 		//	- +---		: 	Indicates a top-level dependency
 		//	- \---		: 	Indicates the last top-level dependency
-		//	- |  +--- 	: 	Indicates a child dependency. The depth is equal to the number of | chars
-		//	- |  \---	:	Indicates the last entry at the current level. The depth is equal to the number of | chars
-		if dependencyTreeRegex.MatchString(entry) {
-			// Count the pipes
-			depth := strings.Count(entry, "|    ")
-			if depth > 0 {
-				// We are mutating array contents, so we can't use normal assignment or Go will
-				// transparently copy the data
-				curr := &dependencies
-				for i := 1; i < depth-1; i++ {
-					curr = &(*curr)[len(*curr)-1].Children
-				}
+		//	- |    +--- 	: 	Indicates a child dependency
+		//	- |    \---	:	Indicates the last entry at the current level
+		//
+		// Each level is indented by five characters, which are either "|    " or
+		// blank if the parent was the last entry at its own level.
+		r := dependencyTreeRegex.FindStringSubmatch(strings.TrimRight(entry, "\r"))
+		if r == nil {
+			continue
+		}
+		depth := len(r[1]) / 5
 
-				var parent = &(*curr)[len(*curr)-1]
+		// Children of a skipped entry are skipped along with it
+		if skipDepth >= 0 && depth > skipDepth {
+			continue
+		}
+		skipDepth = -1
 
-				dep := g.parseDependency(entry)
-				if dep != nil {
-					parent.Children = append(parent.Children, *dep)
-				}
-			} else {
-				dep := g.parseDependency(entry)
-				if dep != nil {
-					dependencies = append(dependencies, *dep)
-				}
-			}
+		// Gradle only marks repeated entries with (*) when they have children of
+		// their own, so leaf dependencies can show up more than once
+		dep := g.parseDependency(entry)
+		if dep == nil || seen[dep.GroupId+":"+dep.ArtifactId] {
+			skipDepth = depth
+			continue
+		}
+		seen[dep.GroupId+":"+dep.ArtifactId] = true
+
+		// We are mutating array contents, so we can't use normal assignment or Go will
+		// transparently copy the data
+		curr := &dependencies
+		for i := 0; i < depth && len(*curr) > 0; i++ {
+			curr = &(*curr)[len(*curr)-1].Children
 		}
+		*curr = append(*curr, *dep)
 	}
 
 	return dependencies
@@ -185,50 +297,217 @@ func (g *Gradle) Analyze() models.Project {
 		log.Debug("Logging Gradle command output")
 	}
 
-	if g.GradleCommand == "" {
+	if g.GradleCommand == "" && g.RootCtx.ReplayDir == "" {
 		g.GradleCommand = g.findGradleExecutable()
 	}
 
 	log.Infof("Running Gradle command (%s)", g.GradleCommand)
 
-	// Run dependency:tree tool
-	var out bytes.Buffer
-	cmd := exec.Command(g.GradleCommand,
-		"-p",
-		g.BuildGradleFile,
+	// Prefer the resolved graph reported by the init script, and only scrape
+	// the dependencies report if the script doesn't work with this build
+	projectPath := g.ChildModule
+	if projectPath == "" {
+		projectPath = ":"
+	}
+	if reports, ok := g.runInitScript([]string{projectPath}); ok {
+		if !reports[0].Found {
+			log.Fatalf("Project %s has no %s configuration", reports[0].Path, g.Configuration)
+		}
+		return reports[0].toProject()
+	}
+
+	// Run dependencies task
+	recorder := g.recorder()
+	result := recorder.Run("dependencies", g.GradleCommand, append(g.baseArgs(),
 		"-q",
 		fmt.Sprintf("%s:dependencies", g.ChildModule),
 		"--configuration",
-		g.Configuration)
-	stdout, err := cmd.StdoutPipe()
-	scanner := bufio.NewScanner(stdout)
-	running := true
-
-	go func() {
-		for scanner.Scan() {
-			line := scanner.Text()
-			log.Debug(line)
-			out.WriteString(line)
-			out.Write([]byte("\n"))
-		}
-		if err := scanner.Err(); err != nil {
-			log.Error(os.Stderr, "reading standard input:", err)
-		}
-		running = false
-	}()
-
-	err = cmd.Run()
-	output := out.String()
-	if err != nil {
-		log.Error(err)
-		g.describeError(output)
+		g.Configuration)...)
+	output := result.Stdout
+	if result.Err != nil {
+		log.Error(result.Err)
+		g.describeError(output + result.Stderr)
 	}
 
-	deps := g.parseOutputTree(output)
-	name, version := g.parseProjectDetails()
+	project := g.Parse(output)
+	project.Name, project.Version = g.parseProjectDetails()
+	return project
+}
+
+// Parse builds a project from previously captured dependencies report output.
+// No Gradle command is run; artifact sizes are resolved against GradleCache.
+// The report does not include the project version, so it is left empty.
+func (g *Gradle) Parse(output string) models.Project {
 	return models.Project{
-		Name:         name,
-		Version:      version,
-		Dependencies: deps,
+		Name:         g.parseProjectHeader(output),
+		Dependencies: g.parseOutputTree(output),
+	}
+}
+
+// Returns the path of a task in the given project
+func taskPath(projectPath string, task string) string {
+	if projectPath == ":" {
+		return ":" + task
+	}
+	return projectPath + ":" + task
+}
+
+// Lists the path of every project in the build, starting with the root. The
+// projects task is authoritative, but if it fails we fall back to reading the
+// include statements in the settings file.
+func (g *Gradle) discoverProjects() []string {
+	recorder := g.recorder()
+	result := recorder.Run("projects", g.GradleCommand, append(g.baseArgs(), "-q", "projects")...)
+
+	var paths []string
+	if result.Err == nil {
+		for _, r := range regexSubproject.FindAllStringSubmatch(result.Stdout, -1) {
+			paths = append(paths, r[1])
+		}
+	} else {
+		log.Warnf("Unable to list projects with Gradle, reading the settings file instead: %s", result.Err)
+		g.describeError(result.Stdout + result.Stderr)
+		paths = g.readSettingsProjects()
+	}
+	log.Debugf("Found projects: %s", strings.Join(paths, ", "))
+	return append([]string{":"}, paths...)
+}
+
+// Reads the projects included by settings.gradle or settings.gradle.kts. Only
+// literal project paths can be found this way, for example:
+//
+//	include 'api', 'services:web'
+//	include(":api", ":services:web")
+func (g *Gradle) readSettingsProjects() []string {
+	var paths []string
+	for _, name := range []string{"settings.gradle", "settings.gradle.kts"} {
+		data, err := ioutil.ReadFile(filepath.Join(g.projectDir(), name))
+		if err != nil {
+			continue
+		}
+		for _, include := range regexSettingInclude.FindAllStringSubmatch(string(data), -1) {
+			for _, r := range regexQuoted.FindAllStringSubmatch(include[1], -1) {
+				p := r[1]
+				if !strings.HasPrefix(p, ":") {
+					p = ":" + p
+				}
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
+
+type projectSection struct {
+	name   string
+	output string
+}
+
+// Splits the output of a task run for several projects into the part printed
+// for each one. Gradle prints a header naming the project before each report.
+func splitProjectSections(output string) []projectSection {
+	var sections []projectSection
+	matches := regexProjectHeader.FindAllStringSubmatchIndex(output, -1)
+	for i, match := range matches {
+		end := len(output)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		sections = append(sections, projectSection{
+			name:   output[match[2]:match[3]],
+			output: output[match[0]:end],
+		})
+	}
+	return sections
+}
+
+// Runs the properties task of each project and returns their versions, keyed
+// by the name in the project header
+func (g *Gradle) parseProjectVersions(paths []string) map[string]string {
+	args := append(g.baseArgs(), "-q", "--continue")
+	for _, p := range paths {
+		args = append(args, taskPath(p, "properties"))
+	}
+	recorder := g.recorder()
+	result := recorder.Run("properties", g.GradleCommand, args...)
+	if result.Err != nil {
+		log.Warnf("Gradle failed to list the properties of some projects: %s", result.Err)
+		g.describeError(result.Stdout + result.Stderr)
+	}
+
+	versions := map[string]string{}
+	for _, section := range splitProjectSections(result.Stdout) {
+		if r := regexProjectVersion.FindStringSubmatch(section.output); r != nil {
+			versions[section.name] = strings.TrimSpace(r[1])
+		}
+	}
+	return versions
+}
+
+// AnalyzeProjects builds a project for every project of a multi-project build.
+// The dependencies task of every project runs in a single Gradle invocation.
+func (g *Gradle) AnalyzeProjects() []models.Project {
+	if g.GradleCommand == "" && g.RootCtx.ReplayDir == "" {
+		g.GradleCommand = g.findGradleExecutable()
+	}
+
+	paths := g.discoverProjects()
+	log.Infof("Running Gradle command (%s) for %d projects", g.GradleCommand, len(paths))
+
+	if reports, ok := g.runInitScript(paths); ok {
+		var projects []models.Project
+		for _, report := range reports {
+			if !report.Found {
+				log.Debugf("Project %s has no %s configuration, skipping it", report.Path, g.Configuration)
+				continue
+			}
+			projects = append(projects, report.toProject())
+		}
+		if len(projects) == 0 {
+			log.Fatalf("No projects with the %s configuration found in Gradle output", g.Configuration)
+		}
+		return projects
+	}
+
+	// Task options only apply to the task before them, so the configuration
+	// has to be given for each one. Projects that don't have the configuration
+	// fail, which --continue lets us skip.
+	args := append(g.baseArgs(), "-q", "--continue")
+	for _, p := range paths {
+		args = append(args, taskPath(p, "dependencies"), "--configuration", g.Configuration)
+	}
+	recorder := g.recorder()
+	result := recorder.Run("dependencies", g.GradleCommand, args...)
+	if result.Err != nil {
+		log.Warnf("Gradle failed to list the dependencies of some projects: %s", result.Err)
+		g.describeError(result.Stdout + result.Stderr)
+	}
+
+	projects := g.ParseProjects(result.Stdout)
+	versions := g.parseProjectVersions(paths)
+	for i := range projects {
+		projects[i].Version = versions[projects[i].Name]
+	}
+	return projects
+}
+
+// ParseProjects builds a project for each dependencies report in previously
+// captured output of a multi-project build. Projects that don't have the
+// configuration are left out.
+func (g *Gradle) ParseProjects(output string) []models.Project {
+	var projects []models.Project
+	for _, section := range splitProjectSections(output) {
+		if !g.configurationRegex().MatchString(section.output) {
+			log.Debugf("Project %s has no %s configuration, skipping it", section.name, g.Configuration)
+			continue
+		}
+		projects = append(projects, models.Project{
+			Name:         section.name,
+			Dependencies: g.parseOutputTree(section.output),
+		})
+	}
+	if len(projects) == 0 {
+		log.Fatalf("No projects with the %s configuration found in Gradle output", g.Configuration)
 	}
+	return projects
 }
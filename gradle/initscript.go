@@ -0,0 +1,169 @@
+package gradle
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sif/models"
+	"strings"
+)
+
+const (
+	initScriptTask  = "sifDependencies"
+	initScriptBegin = "SIF-JSON-BEGIN"
+	initScriptEnd   = "SIF-JSON-END"
+)
+
+//go:embed sif-init.gradle
+var initScript string
+
+type initScriptFile struct {
+	Path string `json:"path"`
+	Size uint64 `json:"size"`
+}
+
+type initScriptNode struct {
+	Group    string           `json:"group"`
+	Module   string           `json:"module"`
+	Version  string           `json:"version"`
+	Project  string           `json:"project"`
+	Failed   bool             `json:"failed"`
+	Reasons  []string         `json:"reasons"`
+	Files    []initScriptFile `json:"files"`
+	Children []initScriptNode `json:"children"`
+}
+
+// initScriptReport is what the sifDependencies task prints for each project
+type initScriptReport struct {
+	Name          string           `json:"name"`
+	Path          string           `json:"path"`
+	Version       string           `json:"version"`
+	Configuration string           `json:"configuration"`
+	Found         bool             `json:"found"`
+	Dependencies  []initScriptNode `json:"dependencies"`
+}
+
+// Runs the sifDependencies task added by the init script in each of the given
+// projects. Returns false if Gradle didn't print any reports, for example
+// because it is too old to run the script.
+func (g *Gradle) runInitScript(paths []string) ([]initScriptReport, bool) {
+	recorder := g.recorder()
+	if !recorder.Has("init-script") {
+		return nil, false
+	}
+
+	f, err := ioutil.TempFile("", "sif-init-*.gradle")
+	if err != nil {
+		log.Fatalf("Failed to create Gradle init script: %s", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(initScript); err != nil {
+		log.Fatalf("Failed to write Gradle init script: %s", err)
+	}
+	f.Close()
+
+	args := append(g.baseArgs(), "-q", "--continue", "--init-script", f.Name())
+	for _, p := range paths {
+		args = append(args, taskPath(p, initScriptTask))
+	}
+	args = append(args, fmt.Sprintf("-Psif.configuration=%s", g.Configuration))
+	result := recorder.Run("init-script", g.GradleCommand, args...)
+	if result.Err != nil {
+		g.describeError(result.Stdout + result.Stderr)
+	}
+
+	reports := parseInitScriptOutput(result.Stdout)
+	if len(reports) == 0 {
+		log.Warnf("Gradle init script produced no output (%v), falling back to the dependencies report", result.Err)
+		return nil, false
+	}
+	return reports, true
+}
+
+// Extracts the JSON reports from the task output. Anything else the build
+// prints is ignored.
+func parseInitScriptOutput(output string) []initScriptReport {
+	var reports []initScriptReport
+	var body []string
+	inReport := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case line == initScriptBegin:
+			inReport = true
+			body = nil
+		case line == initScriptEnd && inReport:
+			inReport = false
+			var report initScriptReport
+			if err := json.Unmarshal([]byte(strings.Join(body, "\n")), &report); err != nil {
+				log.Warnf("Unable to parse Gradle init script output: %s", err)
+				continue
+			}
+			reports = append(reports, report)
+		case inReport:
+			body = append(body, line)
+		}
+	}
+	return reports
+}
+
+func (r *initScriptReport) toProject() models.Project {
+	name := r.Name
+	if r.Path != ":" {
+		name = r.Path
+	}
+	version := r.Version
+	if version == "unspecified" {
+		version = ""
+	}
+	return models.Project{
+		Name:         name,
+		Version:      version,
+		Dependencies: toDependencies(r.Dependencies),
+	}
+}
+
+// Converts the resolved graph into dependencies. Sizes come from the files
+// Gradle resolved, so the cache doesn't need to be searched.
+func toDependencies(nodes []initScriptNode) []models.Dependency {
+	var deps []models.Dependency
+	for _, node := range nodes {
+		if node.Project != "" {
+			deps = append(deps, models.Dependency{
+				ArtifactId: node.Project,
+				Status:     models.StatusProject,
+				Children:   toDependencies(node.Children),
+			})
+			continue
+		}
+
+		dep := models.Dependency{
+			GroupId:         node.Group,
+			ArtifactId:      node.Module,
+			Version:         node.Version,
+			Extension:       "jar",
+			SelectionReason: strings.Join(node.Reasons, "; "),
+			Children:        toDependencies(node.Children),
+		}
+		switch {
+		case node.Failed:
+			dep.Status = models.StatusMissing
+		case len(node.Files) == 0:
+			dep.Status = models.StatusPomOnly
+		default:
+			dep.Status = models.StatusFound
+			dep.Extension = strings.TrimPrefix(filepath.Ext(node.Files[0].Path), ".")
+			dep.File = node.Files[0].Path
+			for _, file := range node.Files {
+				dep.Size += file.Size
+				dep.SearchedPaths = append(dep.SearchedPaths, file.Path)
+			}
+		}
+		deps = append(deps, dep)
+	}
+	return deps
+}
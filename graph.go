@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"io"
+	"sif/models"
+	"strings"
+)
+
+// graphNode is a unique dependency as it is drawn in a graph
+type graphNode struct {
+	id    string
+	label []string
+	large bool
+	// Its size couldn't be found, so it is drawn dashed
+	unresolved bool
+}
+
+// graphExport is the dependency graph of a project ready to be written out as
+// DOT or Mermaid. Each dependency is a single node, with an edge for every
+// dependency that pulls it in.
+type graphExport struct {
+	title string
+	nodes []graphNode
+	edges [][2]string
+}
+
+func toGraphExport(project models.Project) graphExport {
+	analyzedDeps := calculateTotalSizes(project)
+	graph := buildDependencyGraph(analyzedDeps)
+	_, classpath := countDependencies(analyzedDeps)
+
+	title := fmt.Sprintf("%s %s", project.Name, project.Version)
+	export := graphExport{
+		title: title,
+		nodes: []graphNode{{id: "project", label: []string{title, humanize.Bytes(classpath)}}},
+	}
+
+	ids := map[string]string{}
+	for i, key := range graph.order {
+		dep := graph.deps[key]
+		name := dep.Coordinates()
+		if dep.Classifier != "" {
+			name += ":" + dep.Classifier
+		}
+		node := graphNode{id: fmt.Sprintf("n%d", i+1), label: []string{name, humanize.Bytes(dep.Size)}}
+		if dep.Unresolved() {
+			node.label[1] = "size unknown"
+			node.unresolved = true
+		}
+		node.large = dep.Size > rootCtx.LargeDependencyThresholdBytes
+		ids[key] = node.id
+		export.nodes = append(export.nodes, node)
+	}
+
+	// Omitted entries are drawn as edges to the version that was picked
+	seen := map[string]bool{}
+	addEdge := func(from, to string) {
+		if from != "" && to != "" && !seen[from+" "+to] {
+			seen[from+" "+to] = true
+			export.edges = append(export.edges, [2]string{from, to})
+		}
+	}
+	for _, root := range graph.roots {
+		addEdge("project", ids[root])
+	}
+	for _, key := range graph.order {
+		for _, child := range graph.children[key] {
+			addEdge(ids[key], ids[child])
+		}
+	}
+	return export
+}
+
+var dotEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// Writes the dependency graph in Graphviz DOT format
+func printDot(project models.Project, w io.Writer) {
+	export := toGraphExport(project)
+	fmt.Fprintf(w, "digraph \"%s\" {\n", dotEscape.Replace(export.title))
+	fmt.Fprintf(w, "  rankdir=LR;\n")
+	fmt.Fprintf(w, "  node [shape=box, fontname=\"Helvetica\"];\n")
+	for _, node := range export.nodes {
+		var lines []string
+		for _, line := range node.label {
+			lines = append(lines, dotEscape.Replace(line))
+		}
+		attrs := []string{`label="` + strings.Join(lines, `\n`) + `"`}
+		if node.large {
+			attrs = append(attrs, `color="red"`, `fontcolor="red"`)
+		}
+		if node.unresolved {
+			attrs = append(attrs, `style="dashed"`)
+		}
+		fmt.Fprintf(w, "  %s [%s];\n", node.id, strings.Join(attrs, ", "))
+	}
+	for _, edge := range export.edges {
+		fmt.Fprintf(w, "  %s -> %s;\n", edge[0], edge[1])
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
+// Writes the dependency graph as a Mermaid flowchart
+func printMermaid(project models.Project, w io.Writer) {
+	export := toGraphExport(project)
+	escape := strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;")
+	var large, unresolved []string
+
+	fmt.Fprintf(w, "graph LR\n")
+	for _, node := range export.nodes {
+		var lines []string
+		for _, line := range node.label {
+			lines = append(lines, escape.Replace(line))
+		}
+		fmt.Fprintf(w, "  %s[\"%s\"]\n", node.id, strings.Join(lines, "<br/>"))
+		if node.large {
+			large = append(large, node.id)
+		}
+		if node.unresolved {
+			unresolved = append(unresolved, node.id)
+		}
+	}
+	for _, edge := range export.edges {
+		fmt.Fprintf(w, "  %s --> %s\n", edge[0], edge[1])
+	}
+	if len(large) > 0 {
+		fmt.Fprintf(w, "  classDef large fill:#fdd,stroke:#d00,color:#900\n")
+		fmt.Fprintf(w, "  class %s large\n", strings.Join(large, ","))
+	}
+	if len(unresolved) > 0 {
+		fmt.Fprintf(w, "  classDef unresolved stroke-dasharray:5 5\n")
+		fmt.Fprintf(w, "  class %s unresolved\n", strings.Join(unresolved, ","))
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	_ "embed"
+	log "github.com/sirupsen/logrus"
+	"html/template"
+	"io"
+	"sif/models"
+)
+
+//go:embed report.html
+var reportTemplate string
+
+// htmlReport is everything the HTML report shows. The page is rendered in the
+// browser from this, so the file works offline.
+type htmlReport struct {
+	Project      jsonProject
+	Dependencies []jsonTopEntry
+	SifVersion   string
+}
+
+// Writes a single HTML page with a treemap of the dependency tree and a table
+// of every unique dependency
+func printHTML(project models.Project, w io.Writer) {
+	entries, classpath := rankDependencies(project, TopByOwn)
+	report := htmlReport{
+		Project:      toJSONProject(project),
+		Dependencies: toJSONTopEntries(entries, classpath, TopByOwn),
+		SifVersion:   Version,
+	}
+
+	t, err := template.New("report").Parse(reportTemplate)
+	if err != nil {
+		log.Fatalf("Failed to parse HTML report template: %s", err)
+	}
+	if err := t.Execute(w, report); err != nil {
+		log.Fatalf("Failed to write HTML report: %s", err)
+	}
+}
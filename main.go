@@ -7,6 +7,8 @@ import (
 	"github.com/mitchellh/go-homedir"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"os"
+	"path"
 	"path/filepath"
 	"sif/gradle"
 	"sif/maven"
@@ -19,6 +21,17 @@ var (
 	rootCtx   = models.RootCtx{}
 	mavenCtx  = maven.Maven{}
 	gradleCtx = gradle.Gradle{}
+	parseCtx  = SavedOutput{}
+	sbomCtx   = SBOMImport{}
+
+	// Scopes to compare with --scopes, in place of the single --scope
+	mavenScopes []string
+)
+
+const (
+	defaultMavenRepo   = "~/.m2/repository"
+	defaultGradleCache = "~/.gradle/caches/modules-2/files-2.1"
+	defaultNpmCache    = "~/.npm/_cacache"
 )
 
 type AnalyzedDependency struct {
@@ -57,6 +70,50 @@ func processRootConfig() models.RootCtx {
 		log.Fatalf("Unable to parse threshold %s as a size", rootCtx.LargeDependencyThreshold)
 	}
 	rootCtx.LargeDependencyThresholdBytes = b
+	b, err = humanize.ParseBytes(rootCtx.MinSize)
+	if err != nil {
+		log.Fatalf("Unable to parse minimum size %s as a size", rootCtx.MinSize)
+	}
+	rootCtx.MinSizeBytes = b
+
+	switch rootCtx.Sort {
+	case "", SortBySize, SortByTotal, SortByName:
+	default:
+		log.Fatalf("Unknown --sort order: %s", rootCtx.Sort)
+	}
+	if rootCtx.MaxDepth < 0 {
+		log.Fatalf("--max-depth must be a positive number")
+	}
+	for _, glob := range append(rootCtx.Include, rootCtx.Exclude...) {
+		if _, err := path.Match(glob, ""); err != nil {
+			log.Fatalf("Invalid pattern %s: %s", glob, err)
+		}
+	}
+
+	if rootCtx.SaveRawDir != "" && rootCtx.ReplayDir != "" {
+		log.Fatalf("The --save-raw and --replay options cannot be used together")
+	}
+	if rootCtx.SaveRawDir != "" {
+		rootCtx.SaveRawDir = resolvePath(rootCtx.SaveRawDir)
+	}
+	if rootCtx.ReplayDir != "" {
+		rootCtx.ReplayDir = resolvePath(rootCtx.ReplayDir)
+	}
+
+	switch rootCtx.TopBy {
+	case TopByOwn, TopByTotal, TopByExclusive:
+	default:
+		log.Fatalf("Unknown --top-by size: %s", rootCtx.TopBy)
+	}
+	if rootCtx.Top < 0 {
+		log.Fatalf("--top must be a positive number")
+	}
+	if rootCtx.TUI && rootCtx.OutputFormat != OutputTree {
+		log.Fatalf("The --tui and --output options cannot be used together")
+	}
+	if rootCtx.Top > 0 && rootCtx.Reverse {
+		log.Fatalf("The --top and --reverse options cannot be used together")
+	}
 
 	switch strings.ToUpper(rootCtx.LogLevel) {
 	case "TRACE":
@@ -93,12 +150,93 @@ func initCli() *cobra.Command {
 		"",
 		"3MB",
 		"The location of the Maven repository to use")
+	rootCmd.PersistentFlags().StringVarP(&rootCtx.OutputFormat,
+		"output",
+		"o",
+		OutputTree,
+		fmt.Sprintf("The output format to use (%s, %s, %s, %s, %s, %s, %s or %s)",
+			OutputTree, OutputJSON, OutputHTML, OutputDot, OutputMermaid, OutputCycloneDX, OutputCycloneDXXML, OutputSPDX))
+	rootCmd.PersistentFlags().BoolVarP(&rootCtx.Strict,
+		"strict",
+		"",
+		false,
+		"Fails if the file for any dependency can't be found")
 	rootCmd.PersistentFlags().BoolVarP(&rootCtx.LargeDependenciesOnly,
 		"large-deps-only",
 		"",
 		false,
 		"Only show dependency trees that exceed the threshold")
+	rootCmd.PersistentFlags().StringVarP(&rootCtx.Sort,
+		"sort",
+		"",
+		"",
+		fmt.Sprintf("Sorts the dependencies at each level of the tree (%s, %s or %s)", SortBySize, SortByTotal, SortByName))
+	rootCmd.PersistentFlags().IntVarP(&rootCtx.MaxDepth,
+		"max-depth",
+		"",
+		0,
+		"Only shows the tree this many levels deep")
+	rootCmd.PersistentFlags().StringArrayVarP(&rootCtx.Include,
+		"include",
+		"",
+		nil,
+		"Only shows dependencies whose groupId:artifactId matches this glob, and what pulls them in")
+	rootCmd.PersistentFlags().StringArrayVarP(&rootCtx.Exclude,
+		"exclude",
+		"",
+		nil,
+		"Hides dependencies whose groupId:artifactId matches this glob, along with everything under them")
+	rootCmd.PersistentFlags().StringVarP(&rootCtx.MinSize,
+		"min-size",
+		"",
+		"0",
+		"Hides dependencies whose total size is smaller than this")
+	rootCmd.PersistentFlags().IntVarP(&rootCtx.Top,
+		"top",
+		"",
+		0,
+		"Lists the N largest unique dependencies instead of the tree")
+	rootCmd.PersistentFlags().StringVarP(&rootCtx.TopBy,
+		"top-by",
+		"",
+		TopByOwn,
+		fmt.Sprintf("What --top ranks dependencies by (%s, %s or %s)", TopByOwn, TopByTotal, TopByExclusive))
+	rootCmd.PersistentFlags().BoolVarP(&rootCtx.TUI,
+		"tui",
+		"",
+		false,
+		"Opens an interactive explorer for the tree instead of printing it")
+	rootCmd.PersistentFlags().BoolVarP(&rootCtx.Collapse,
+		"collapse",
+		"",
+		false,
+		"Prints repeated subtrees in full the first time only, and refers back to them after that")
+	rootCmd.PersistentFlags().BoolVarP(&rootCtx.Reverse,
+		"reverse",
+		"",
+		false,
+		"Shows each unique dependency with the dependencies that pull it in below it")
 
+	rootCmd.AddCommand(newMavenCmd(defaultReporter))
+	rootCmd.AddCommand(newGradleCmd(defaultReporter))
+	rootCmd.AddCommand(newParseCmd(defaultReporter))
+	rootCmd.AddCommand(newSBOMCmd(defaultReporter))
+	rootCmd.AddCommand(newWhyCmd())
+	rootCmd.AddCommand(newServeCmd())
+	return rootCmd
+}
+
+// Returns the file used to cache the size of artifacts looked up remotely
+func remoteCacheFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		log.Warnf("Unable to find a cache directory, remote sizes will not be cached: %s", err)
+		return ""
+	}
+	return filepath.Join(dir, "sif", "remote-sizes.json")
+}
+
+func newMavenCmd(report reporter) *cobra.Command {
 	mavenCmd := cobra.Command{
 		Use:   "maven [options] path/to/pom.xml",
 		Short: "Analyzes a Maven project's dependencies",
@@ -109,33 +247,100 @@ func initCli() *cobra.Command {
 			} else {
 				mavenCtx.PomFile = resolvePath(args[0])
 				mavenCtx.MavenRepo = resolvePath(mavenCtx.MavenRepo)
+				mavenCtx.SettingsFile = resolvePath(mavenCtx.SettingsFile)
+				mavenCtx.RemoteCacheFile = remoteCacheFile()
 				mavenCtx.RootCtx = processRootConfig()
-				printResult(mavenCtx.Analyze())
+				if len(mavenScopes) > 0 {
+					if mavenCtx.AllModules {
+						log.Fatalf("The --scopes and --all-modules options cannot be used together")
+					}
+					report.scopes(analyzeScopes(mavenCtx, mavenScopes))
+				} else if mavenCtx.AllModules {
+					if mavenCtx.ChildModule != "" {
+						log.Fatalf("The --child and --all-modules options cannot be used together")
+					}
+					report.modules(mavenCtx.AnalyzeModules())
+				} else {
+					report.project(mavenCtx.Analyze())
+				}
 			}
 		},
 	}
 	mavenCmd.PersistentFlags().StringVarP(&mavenCtx.MavenCommand,
 		"cmd",
 		"",
-		"mvn",
-		"Path to Maven command")
+		"",
+		"Path to Maven command (defaults to searching)")
 	mavenCmd.PersistentFlags().StringVarP(&mavenCtx.Scope,
 		"scope",
 		"",
 		"compile",
 		"The project scope to use")
+	mavenCmd.PersistentFlags().StringSliceVarP(&mavenScopes,
+		"scopes",
+		"",
+		nil,
+		"Comma-delimited list of scopes to compare, showing the size each dependency adds to each scope")
 	mavenCmd.PersistentFlags().StringVarP(&mavenCtx.MavenRepo,
 		"repo",
 		"",
-		"~/.m2/repository",
+		defaultMavenRepo,
 		"The location of the Maven repository to use")
 	mavenCmd.PersistentFlags().StringVarP(&mavenCtx.ChildModule,
 		"child",
 		"",
 		"",
 		"Specifies a child module in a multi-module project (defaults to none)")
-	rootCmd.AddCommand(&mavenCmd)
+	mavenCmd.PersistentFlags().BoolVarP(&mavenCtx.AllModules,
+		"all-modules",
+		"",
+		false,
+		"Analyzes every module in a multi-module project, along with an aggregate of all of them")
+	mavenCmd.PersistentFlags().StringVarP(&mavenCtx.RemoteRepo,
+		"remote-repo",
+		"",
+		"",
+		"A remote repository URL used to look up the size of artifacts missing from the local repository")
+	mavenCmd.PersistentFlags().StringVarP(&mavenCtx.RemoteRepoId,
+		"remote-repo-id",
+		"",
+		"central",
+		"The ID of the remote repository, used to match mirrors and credentials in the settings file")
+	mavenCmd.PersistentFlags().StringVarP(&mavenCtx.SettingsFile,
+		"settings",
+		"",
+		"~/.m2/settings.xml",
+		"The Maven settings file to use")
+	mavenCmd.PersistentFlags().BoolVarP(&mavenCtx.Native,
+		"native",
+		"",
+		false,
+		"Resolves dependencies from the POM and local repository without running Maven")
+	mavenCmd.PersistentFlags().BoolVarP(&mavenCtx.Verbose,
+		"verbose",
+		"",
+		false,
+		"Also shows dependencies omitted due to version conflicts, and versions changed by dependency management")
+	mavenCmd.PersistentFlags().StringSliceVarP(&mavenCtx.Profiles,
+		"activate-profiles",
+		"P",
+		nil,
+		"Comma-delimited list of Maven profiles to activate")
+	mavenCmd.PersistentFlags().StringArrayVarP(&mavenCtx.Properties,
+		"define",
+		"D",
+		nil,
+		"Defines a system property for Maven (key=value), may be repeated")
+	mavenCmd.PersistentFlags().BoolVarP(&mavenCtx.Offline,
+		"offline",
+		"",
+		false,
+		"Runs Maven in offline mode")
+	addRawOutputFlags(&mavenCmd)
+	return &mavenCmd
+}
 
+func newGradleCmd(report reporter) *cobra.Command {
 	gradleCmd := cobra.Command{
 		Use:   "gradle [options] path/to/build.gradle",
 		Short: "Analyzes a Gradle project's dependencies",
@@ -145,8 +350,19 @@ func initCli() *cobra.Command {
 				cmd.Help()
 			} else {
 				gradleCtx.BuildGradleFile = resolvePath(args[0])
-				mavenCtx.RootCtx = processRootConfig()
-				printResult(gradleCtx.Analyze())
+				gradleCtx.GradleCache = resolvePath(gradleCtx.GradleCache)
+				for i, script := range gradleCtx.InitScripts {
+					gradleCtx.InitScripts[i] = resolvePath(script)
+				}
+				gradleCtx.RootCtx = processRootConfig()
+				if gradleCtx.AllProjects {
+					if gradleCtx.ChildModule != "" {
+						log.Fatalf("The --child and --all-projects options cannot be used together")
+					}
+					report.modules(gradleCtx.AnalyzeProjects())
+				} else {
+					report.project(gradleCtx.Analyze())
+				}
 			}
 		},
 	}
@@ -160,13 +376,130 @@ func initCli() *cobra.Command {
 		"",
 		"runtimeClasspath",
 		"The dependency configuration to use")
+	gradleCmd.PersistentFlags().StringVarP(&gradleCtx.GradleCache,
+		"cache",
+		"",
+		defaultGradleCache,
+		"The location of the Gradle module cache to use")
 	gradleCmd.PersistentFlags().StringVarP(&gradleCtx.ChildModule,
 		"child",
 		"",
 		"",
 		"Specifies a child module in a multi-module project (defaults to none)")
-	rootCmd.AddCommand(&gradleCmd)
-	return rootCmd
+	gradleCmd.PersistentFlags().BoolVarP(&gradleCtx.AllProjects,
+		"all-projects",
+		"",
+		false,
+		"Analyzes every project in a multi-project build, along with an aggregate of all of them")
+	gradleCmd.PersistentFlags().StringArrayVarP(&gradleCtx.InitScripts,
+		"init-script",
+		"",
+		nil,
+		"An init script for Gradle to run, may be repeated")
+	gradleCmd.PersistentFlags().StringArrayVarP(&gradleCtx.ProjectProperties,
+		"project-prop",
+		"P",
+		nil,
+		"Sets a Gradle project property (key=value), may be repeated")
+	gradleCmd.PersistentFlags().StringArrayVarP(&gradleCtx.SystemProperties,
+		"system-prop",
+		"D",
+		nil,
+		"Sets a system property for Gradle (key=value), may be repeated")
+	gradleCmd.PersistentFlags().BoolVarP(&gradleCtx.Offline,
+		"offline",
+		"",
+		false,
+		"Runs Gradle in offline mode")
+	addRawOutputFlags(&gradleCmd)
+	return &gradleCmd
+}
+
+func newParseCmd(report reporter) *cobra.Command {
+	parseCmd := cobra.Command{
+		Use:   "parse [options] path/to/output.txt",
+		Short: "Analyzes previously saved build tool output without running the build",
+		Long: "Analyzes previously saved build tool output without running the build. Use - to read from stdin.\n" +
+			"Supported formats are maven-tree (mvn dependency:tree) and gradle-tree (gradle dependencies).",
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 || args[0] == "help" {
+				cmd.Help()
+			} else {
+				parseCtx.RootCtx = processRootConfig()
+				if parseCtx.AllModules {
+					report.modules(parseCtx.AnalyzeModules(args[0]))
+				} else {
+					report.project(parseCtx.Analyze(args[0]))
+				}
+			}
+		},
+	}
+	parseCmd.PersistentFlags().StringVarP(&parseCtx.Format,
+		"format",
+		"",
+		"",
+		"The format of the saved output (maven-tree or gradle-tree)")
+	parseCmd.PersistentFlags().StringVarP(&parseCtx.Repo,
+		"repo",
+		"",
+		"",
+		fmt.Sprintf("The local repository used to size artifacts (defaults to %s or %s)", defaultMavenRepo, defaultGradleCache))
+	parseCmd.PersistentFlags().StringVarP(&parseCtx.Configuration,
+		"configuration",
+		"",
+		"runtimeClasspath",
+		"The Gradle dependency configuration the report was generated for")
+	parseCmd.PersistentFlags().BoolVarP(&parseCtx.AllModules,
+		"all-modules",
+		"",
+		false,
+		"Analyzes every module or project in the output of a multi-module build")
+	return &parseCmd
+}
+
+func newSBOMCmd(report reporter) *cobra.Command {
+	sbomCmd := cobra.Command{
+		Use:   "sbom [options] path/to/sbom.json",
+		Short: "Analyzes the components listed in a CycloneDX or SPDX SBOM",
+		Long: "Analyzes the components listed in a CycloneDX (JSON or XML) or SPDX (JSON) SBOM. Use - to read from stdin.\n" +
+			"Maven components are sized from the local repository and npm packages from the npm cache. Anything else, or\n" +
+			"anything that can't be found, is sized from the sizes sif adds to the SBOMs it writes.",
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 || args[0] == "help" {
+				cmd.Help()
+			} else {
+				sbomCtx.RootCtx = processRootConfig()
+				report.project(sbomCtx.Analyze(args[0]))
+			}
+		},
+	}
+	sbomCmd.PersistentFlags().StringVarP(&sbomCtx.Repo,
+		"repo",
+		"",
+		"",
+		fmt.Sprintf("The local Maven repository used to size artifacts (defaults to %s)", defaultMavenRepo))
+	sbomCmd.PersistentFlags().StringVarP(&sbomCtx.NpmCache,
+		"npm-cache",
+		"",
+		"",
+		fmt.Sprintf("The npm cache used to size packages (defaults to %s)", defaultNpmCache))
+	return &sbomCmd
+}
+
+// Adds the flags for saving and replaying raw build tool output to an analyzer command
+func addRawOutputFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&rootCtx.SaveRawDir,
+		"save-raw",
+		"",
+		"",
+		"Saves the command line, environment and output of the build tool to this directory")
+	cmd.PersistentFlags().StringVarP(&rootCtx.ReplayDir,
+		"replay",
+		"",
+		"",
+		"Skips running the build tool and re-parses output previously saved with --save-raw")
 }
 
 func initConfig() {
@@ -220,12 +553,43 @@ func calculateTotalSizes(project models.Project) []AnalyzedDependency {
 	return deps
 }
 
-func printResult(project models.Project) {
+// Returns true if the dependency is the final entry in its list of siblings
+func isLastSibling(entry *AnalyzedDependency, topLevel []AnalyzedDependency) bool {
+	siblings := topLevel
+	if entry.Parent != nil {
+		siblings = *entry.Parent.Children
+	}
+	return &siblings[len(siblings)-1] == entry
+}
+
+// Builds the tree drawing prefix for a dependency. Each ancestor contributes a
+// pipe if it has siblings still to come below it, or blank space if it doesn't.
+func treePrefix(entry *AnalyzedDependency, topLevel []AnalyzedDependency) string {
+	prefix := "├── "
+	if isLastSibling(entry, topLevel) {
+		prefix = "└── "
+	}
+	for ptr := entry.Parent; ptr != nil; ptr = ptr.Parent {
+		if isLastSibling(ptr, topLevel) {
+			prefix = "     " + prefix
+		} else {
+			prefix = "│    " + prefix
+		}
+	}
+	return prefix
+}
+
+func printTree(project models.Project) {
 	log.Infof("Project: %s (%s)", project.Name, project.Version)
 
 	if len(project.Dependencies) > 0 {
 		// Depth-first stack walk, printing as we go
 		analyzedDeps := calculateTotalSizes(project)
+		totalDeps, totalSize := countDependencies(analyzedDeps)
+		if rootCtx.Reverse {
+			analyzedDeps = reverseDependencies(analyzedDeps)
+		}
+		analyzedDeps = shapeTree(analyzedDeps, nil, false)
 		var stack DependencyStack
 
 		// Insert these in reverse because stacks operate on the last inserted record
@@ -234,33 +598,17 @@ func printResult(project models.Project) {
 		}
 
 		topLevelCount := 0
-		var totalDeps uint64 = 0
-		var totalSize uint64 = 0
 		var currTopLevel *AnalyzedDependency
+		printedSubtrees := map[string]bool{}
 		for len(stack) > 0 {
 			var entry *AnalyzedDependency
 			stack, entry = stack.Pop()
 			dep := entry.Dependency
-			totalDeps++
-			totalSize += dep.Size
-
-			// The prefix is dependent on the next item in the stack. If the next
-			// item is at the same depth, then we need to include pipes to extend
-			// the tree downwards. If the next item is not at the same depth, then
-			// we need to use the angle character.
-			prefix := "├── "
-			if entry.Depth > 0 {
-				if len(stack) > 0 && stack[len(stack)-1].Depth == entry.Depth {
-					prefix = fmt.Sprintf("%s├── ", strings.Repeat("│    ", entry.Depth))
-				} else {
-					prefix = fmt.Sprintf("%s└── ", strings.Repeat("│    ", entry.Depth))
-				}
-			} else {
+
+			prefix := treePrefix(entry, analyzedDeps)
+			if entry.Depth == 0 {
 				topLevelCount++
 				currTopLevel = entry
-				if len(stack) == 0 && len(*entry.Children) == 0 {
-					prefix = "└── "
-				}
 			}
 
 			// Highlight any file that is greater than than the large file threshold
@@ -273,14 +621,39 @@ func printResult(project models.Project) {
 				totalColor = color.New(color.BgRed)
 			}
 
-			if !rootCtx.LargeDependenciesOnly || currTopLevel.TotalSize > rootCtx.LargeDependencyThresholdBytes {
-				log.Infof("%s%s:%s:%s Size[%s, %s]",
+			// With --collapse, a dependency whose children have already been printed
+			// elsewhere in the tree is only referred back to
+			collapsed := false
+			visible := !rootCtx.LargeDependenciesOnly || currTopLevel.TotalSize > rootCtx.LargeDependencyThresholdBytes
+			if rootCtx.Collapse && visible && len(*entry.Children) > 0 {
+				key := fmt.Sprintf("%s:%s:%s:%s", dep.GroupId, dep.ArtifactId, dep.Version, dep.Classifier)
+				collapsed = printedSubtrees[key]
+				printedSubtrees[key] = true
+			}
+
+			if collapsed {
+				log.Infof("%s%s%s %s",
 					prefix,
-					dep.GroupId,
-					dep.ArtifactId,
-					dep.Version,
-					fileColor.Sprintf("File: %s", humanize.Bytes(dep.Size)),
-					totalColor.Sprintf("Total: %s", humanize.Bytes(entry.TotalSize)))
+					dep.Coordinates(),
+					statusMarker(dep),
+					color.New(color.Faint).Sprintf("(see above, %s)", humanize.Bytes(entry.TotalSize)))
+				continue
+			}
+			if visible {
+				if dep.Omitted() {
+					// Omitted entries aren't on the classpath, so they have no size to show
+					log.Infof("%s%s",
+						prefix,
+						color.New(color.Faint).Sprintf("(%s - omitted for conflict with %s)", dep.Coordinates(), dep.OmittedForConflictWith))
+				} else {
+					log.Infof("%s%s%s%s Size[%s, %s]",
+						prefix,
+						dep.Coordinates(),
+						statusMarker(dep),
+						mediationMarker(dep),
+						fileColor.Sprintf("File: %s", humanize.Bytes(dep.Size)),
+						totalColor.Sprintf("Total: %s", humanize.Bytes(entry.TotalSize)))
+				}
 			}
 
 			// Push all child dependencies in reverse order since stacks operate on the
@@ -291,11 +664,72 @@ func printResult(project models.Project) {
 		}
 
 		log.Infof("%s in %d dependencies", humanize.Bytes(totalSize), totalDeps)
+		printUnresolved(project)
 	} else {
 		log.Infof("0MB in 0 dependencies")
 	}
 }
 
+// Returns a marker for dependencies whose file wasn't found where expected
+func statusMarker(dep *models.Dependency) string {
+	switch dep.Status {
+	case models.StatusMissing:
+		return color.New(color.FgYellow).Sprint(" [MISSING]")
+	case models.StatusPomOnly:
+		return color.New(color.FgYellow).Sprint(" [POM ONLY]")
+	case models.StatusRelocated:
+		return color.New(color.FgCyan).Sprintf(" [RELOCATED to %s]", dep.RelocatedTo)
+	default:
+		return ""
+	}
+}
+
+// Shows how Maven's dependency management changed the version, if it did
+func mediationMarker(dep *models.Dependency) string {
+	if dep.VersionManagedFrom == "" {
+		return ""
+	}
+	return color.New(color.Faint).Sprintf(" (version managed from %s)", dep.VersionManagedFrom)
+}
+
+// Returns each unique dependency whose size could not be determined
+func unresolvedDependencies(project models.Project) []*models.Dependency {
+	var result []*models.Dependency
+	seen := map[string]bool{}
+	var walk func(deps []models.Dependency)
+	walk = func(deps []models.Dependency) {
+		for i := range deps {
+			dep := &deps[i]
+			key := fmt.Sprintf("%s:%s:%s:%s", dep.GroupId, dep.ArtifactId, dep.Version, dep.Classifier)
+			if dep.Unresolved() && !seen[key] {
+				seen[key] = true
+				result = append(result, dep)
+			}
+			walk(dep.Children)
+		}
+	}
+	walk(project.Dependencies)
+	return result
+}
+
+// Lists the artifacts that couldn't be found, since they are counted as 0 bytes
+// and make the totals look smaller than they really are
+func printUnresolved(project models.Project) {
+	unresolved := unresolvedDependencies(project)
+	if len(unresolved) == 0 {
+		return
+	}
+
+	log.Info("")
+	log.Warnf("%d artifacts could not be found and were counted as 0 bytes:", len(unresolved))
+	for _, dep := range unresolved {
+		log.Warnf("  %s%s", dep.Coordinates(), statusMarker(dep))
+		for _, path := range dep.SearchedPaths {
+			log.Warnf("      searched %s", path)
+		}
+	}
+}
+
 type LogFormatter struct {
 }
 
@@ -0,0 +1,1042 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/fatih/color"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sif/gradle"
+	"sif/maven"
+	"sif/models"
+	"sif/recording"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Regenerate golden files with: go test . -update
+var update = flag.Bool("update", false, "update golden files")
+
+// When this is set, the test binary acts as a stand-in for mvn or gradle
+const stubDirEnv = "SIF_STUB_DIR"
+
+// When this is set, the test binary runs sif itself, for tests that call it as
+// a separate process
+const mainEnv = "SIF_TEST_MAIN"
+
+// The directory holding the synthetic repositories for the current test. It is
+// replaced with a placeholder in golden files since it changes on every run.
+var repoRoot string
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mainEnv) != "" {
+		// The build tool it calls should be the stub
+		os.Unsetenv(mainEnv)
+		main()
+		os.Exit(0)
+	}
+	if dir := os.Getenv(stubDirEnv); dir != "" {
+		os.Exit(runStub(dir, os.Args[1:]))
+	}
+	log.SetFormatter(&LogFormatter{})
+	color.NoColor = true
+	flag.Parse()
+	os.Exit(m.Run())
+}
+
+// Replays the output recorded for a command. Fixtures use the same layout as
+// --save-raw, so a bundle sent in by a user can be dropped in as a test case.
+func runStub(dir string, args []string) int {
+	step := "dependencies"
+	var outputType, outputFile string
+	for _, arg := range args {
+		switch {
+		case arg == "dependency:tree":
+			step = "dependency-tree"
+		case arg == "projects":
+			step = "projects"
+		case arg == ":sifDependencies":
+			step = "init-script"
+		case arg == "properties" || arg == ":properties":
+			step = "properties"
+		case strings.HasPrefix(arg, "-DoutputType="):
+			outputType = strings.TrimPrefix(arg, "-DoutputType=")
+		case strings.HasPrefix(arg, "-DoutputFile="):
+			outputFile = strings.TrimPrefix(arg, "-DoutputFile=")
+		}
+	}
+	if outputType != "" {
+		step = step + "-" + outputType
+	}
+
+	stepDir := filepath.Join(dir, step)
+	stdout, err := ioutil.ReadFile(filepath.Join(stepDir, "stdout.txt"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stub has no recorded output for %s: %s\n", strings.Join(args, " "), err)
+		return 1
+	}
+	os.Stdout.Write(stdout)
+
+	// Structured dependency:tree output goes to a file rather than stdout
+	if output, err := ioutil.ReadFile(filepath.Join(stepDir, "output.txt")); err == nil && outputFile != "" {
+		if err := ioutil.WriteFile(outputFile, output, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "stub failed to write %s: %s\n", outputFile, err)
+			return 1
+		}
+	}
+	if stderr, err := ioutil.ReadFile(filepath.Join(stepDir, "stderr.txt")); err == nil {
+		os.Stderr.Write(stderr)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(stepDir, "info.json")); err == nil {
+		var info recording.Info
+		if err := json.Unmarshal(data, &info); err == nil {
+			return info.ExitCode
+		}
+	}
+	return 0
+}
+
+// Builds a synthetic Maven repository and Gradle module cache from the
+// artifacts.txt file in a test case. Each line holds a set of coordinates
+// (groupId:artifactId:type[:classifier]:version) and a file size in bytes.
+func buildRepositories(t *testing.T, caseDir string) (string, string) {
+	root := t.TempDir()
+	repoRoot = root
+	mavenRepo := filepath.Join(root, "m2")
+	gradleCache := filepath.Join(root, "gradle")
+
+	f, err := os.Open(filepath.Join(caseDir, "artifacts.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			t.Fatalf("Bad artifact size in %q: %s", line, err)
+		}
+		coords := strings.Split(fields[0], ":")
+		dep := models.Dependency{GroupId: coords[0], ArtifactId: coords[1], Extension: coords[2], Version: coords[3]}
+		if len(coords) == 5 {
+			dep.Classifier = coords[3]
+			dep.Version = coords[4]
+		}
+
+		createFile(t, filepath.Join(mavenRepo, strings.ReplaceAll(dep.GroupId, ".", "/"), dep.ArtifactId, dep.Version, dep.FileName()), size)
+		createFile(t, filepath.Join(gradleCache, dep.GroupId, dep.ArtifactId, dep.Version, "0123456789abcdef", dep.FileName()), size)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cases can also supply files, such as POMs, to copy into the Maven repository
+	repoDir := filepath.Join(caseDir, "repo")
+	err = filepath.Walk(repoDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(repoDir, file)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(mavenRepo, rel)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(dest, data, 0644)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return mavenRepo, gradleCache
+}
+
+// Builds an npm cache from the npm-packages.txt file in a test case, if it has
+// one. Each line holds a package name, with its scope, a version and a size.
+func buildNpmCache(t *testing.T, caseDir string) string {
+	cache := filepath.Join(repoRoot, "npm")
+	data, err := ioutil.ReadFile(filepath.Join(caseDir, "npm-packages.txt"))
+	if os.IsNotExist(err) {
+		return cache
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			t.Fatalf("Bad package size in %q: %s", line, err)
+		}
+		scope, name := "", fields[0]
+		if i := strings.Index(name, "/"); i >= 0 {
+			scope, name = name[:i], name[i+1:]
+		}
+
+		digest := sha512.Sum512([]byte(fields[0] + "@" + fields[1]))
+		integrity := "sha512-" + base64.StdEncoding.EncodeToString(digest[:])
+		createFile(t, npmContentPath(cache, integrity), size)
+
+		key := "make-fetch-happen:request-cache:" + npmTarballURL(scope, name, fields[1])
+		entry, _ := json.Marshal(npmIndexEntry{Key: key, Integrity: integrity, Size: uint64(size)})
+		bucket := sha256.Sum256([]byte(key))
+		hash := hex.EncodeToString(bucket[:])
+		entryHash := sha1.Sum(entry)
+		index := fmt.Sprintf("\n%s\t%s", hex.EncodeToString(entryHash[:]), entry)
+		dir := filepath.Join(cache, "index-v5", hash[:2], hash[2:4])
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, hash[4:]), []byte(index), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cache
+}
+
+func createFile(t *testing.T, file string, size int64) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testRootCtx() models.RootCtx {
+	return models.RootCtx{
+		LogLevel:                      "INFO",
+		LargeDependencyThreshold:      "1MB",
+		LargeDependencyThresholdBytes: 1000000,
+		OutputFormat:                  OutputTree,
+	}
+}
+
+// Renders the project as a tree and as JSON, and compares both against the
+// golden files in the test case directory
+func assertGolden(t *testing.T, caseDir string, project models.Project) {
+	rootCtx = testRootCtx()
+
+	var tree bytes.Buffer
+	log.SetOutput(&tree)
+	defer log.SetOutput(os.Stderr)
+	printTree(project)
+	compareGolden(t, filepath.Join(caseDir, "tree.golden"), tree.Bytes())
+
+	var out bytes.Buffer
+	printJSON(project, &out)
+	compareGolden(t, filepath.Join(caseDir, "json.golden"), out.Bytes())
+}
+
+// Same as assertGolden, for all of the modules in a build
+func assertModulesGolden(t *testing.T, caseDir string, projects []models.Project) {
+	rootCtx = testRootCtx()
+
+	var tree bytes.Buffer
+	log.SetOutput(&tree)
+	defer log.SetOutput(os.Stderr)
+	printModulesTree(projects)
+	compareGolden(t, filepath.Join(caseDir, "tree.golden"), tree.Bytes())
+
+	var out bytes.Buffer
+	printModulesJSON(projects, &out)
+	compareGolden(t, filepath.Join(caseDir, "json.golden"), out.Bytes())
+}
+
+func compareGolden(t *testing.T, golden string, actual []byte) {
+	t.Helper()
+	if repoRoot != "" {
+		actual = bytes.ReplaceAll(actual, []byte(repoRoot), []byte("$REPO"))
+	}
+	if *update {
+		if err := ioutil.WriteFile(golden, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Failed to read golden file (run with -update to create it): %s", err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("Output does not match %s\n--- expected ---\n%s\n--- actual ---\n%s", golden, expected, actual)
+	}
+}
+
+func stubCommand(t *testing.T, caseDir string) string {
+	dir, err := filepath.Abs(caseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(stubDirEnv, dir)
+	t.Cleanup(func() { os.Unsetenv(stubDirEnv) })
+	return os.Args[0]
+}
+
+// Analyzes a Maven test case with the stub, and resets the options used to
+// render the result
+func analyzeCase(t *testing.T, caseDir string, verbose bool) models.Project {
+	mavenRepo, _ := buildRepositories(t, caseDir)
+	m := maven.Maven{
+		RootCtx:      testRootCtx(),
+		PomFile:      "tests/pom.xml",
+		Scope:        "compile",
+		MavenCommand: stubCommand(t, caseDir),
+		MavenRepo:    mavenRepo,
+		Verbose:      verbose,
+	}
+	project := m.Analyze()
+	rootCtx = testRootCtx()
+	return project
+}
+
+func TestMavenAnalyze(t *testing.T) {
+	for _, name := range []string{"maven-simple", "maven-edge", "maven-json", "maven-verbose"} {
+		t.Run(name, func(t *testing.T) {
+			caseDir := filepath.Join("testdata", name)
+			mavenRepo, _ := buildRepositories(t, caseDir)
+			m := maven.Maven{
+				RootCtx:      testRootCtx(),
+				PomFile:      "tests/pom.xml",
+				Scope:        "compile",
+				MavenCommand: stubCommand(t, caseDir),
+				MavenRepo:    mavenRepo,
+			}
+			assertGolden(t, caseDir, m.Analyze())
+		})
+	}
+}
+
+// A run saved with --save-raw must give the same result when it is replayed,
+// without the build tool
+func TestReplay(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-simple")
+	mavenRepo, _ := buildRepositories(t, caseDir)
+	rawDir := t.TempDir()
+
+	ctx := testRootCtx()
+	ctx.SaveRawDir = rawDir
+	m := maven.Maven{
+		RootCtx:      ctx,
+		PomFile:      "tests/pom.xml",
+		Scope:        "compile",
+		MavenCommand: stubCommand(t, caseDir),
+		MavenRepo:    mavenRepo,
+	}
+	assertGolden(t, caseDir, m.Analyze())
+
+	os.Unsetenv(stubDirEnv)
+	ctx = testRootCtx()
+	ctx.ReplayDir = rawDir
+	m = maven.Maven{
+		RootCtx:   ctx,
+		PomFile:   "tests/pom.xml",
+		Scope:     "compile",
+		MavenRepo: mavenRepo,
+	}
+	assertGolden(t, caseDir, m.Analyze())
+}
+
+func TestMavenAnalyzeModules(t *testing.T) {
+	for _, name := range []string{"maven-modules", "maven-modules-tgf"} {
+		t.Run(name, func(t *testing.T) {
+			caseDir := filepath.Join("testdata", name)
+			mavenRepo, _ := buildRepositories(t, caseDir)
+			m := maven.Maven{
+				RootCtx:      testRootCtx(),
+				PomFile:      "tests/pom.xml",
+				Scope:        "compile",
+				MavenCommand: stubCommand(t, caseDir),
+				MavenRepo:    mavenRepo,
+				AllModules:   true,
+			}
+			assertModulesGolden(t, caseDir, m.AnalyzeModules())
+		})
+	}
+}
+
+// A build that fails for a reason other than the output type must only be run
+// once, since compiling every module again won't make it pass
+func TestMavenBuildFailure(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-compile-failure")
+	rawDir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "maven", "tests/pom.xml", "--all-modules", "--cmd", stubCommand(t, caseDir), "--save-raw", rawDir)
+	cmd.Env = append(os.Environ(), mainEnv+"=true")
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("Expected the analysis to fail, got:\n%s", output)
+	}
+	if !bytes.Contains(output, []byte("Compilation failure")) {
+		t.Errorf("Expected the build's error to be reported, got:\n%s", output)
+	}
+
+	files, err := ioutil.ReadDir(rawDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var steps []string
+	for _, f := range files {
+		steps = append(steps, f.Name())
+	}
+	if strings.Join(steps, ",") != "dependency-tree-json" {
+		t.Errorf("Expected Maven to only be run once, ran %s", strings.Join(steps, ", "))
+	}
+}
+
+func TestMavenScopes(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-native")
+	mavenRepo, _ := buildRepositories(t, caseDir)
+	m := maven.Maven{
+		RootCtx:   testRootCtx(),
+		PomFile:   filepath.Join(caseDir, "pom.xml"),
+		MavenRepo: mavenRepo,
+		Native:    true,
+	}
+	projects := analyzeScopes(m, []string{"compile", "runtime", "test", "provided"})
+	rootCtx = testRootCtx()
+
+	var table bytes.Buffer
+	log.SetOutput(&table)
+	defer log.SetOutput(os.Stderr)
+	printScopesTable(projects)
+	compareGolden(t, filepath.Join(caseDir, "scopes-tree.golden"), table.Bytes())
+
+	var out bytes.Buffer
+	printScopesJSON(projects, &out)
+	compareGolden(t, filepath.Join(caseDir, "scopes-json.golden"), out.Bytes())
+}
+
+func TestWhy(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-verbose")
+	project := analyzeCase(t, caseDir, true)
+	whyQuery = "commons-logging:commons-logging"
+
+	var tree bytes.Buffer
+	log.SetOutput(&tree)
+	defer log.SetOutput(os.Stderr)
+	printWhyTree(project)
+	compareGolden(t, filepath.Join(caseDir, "why-tree.golden"), tree.Bytes())
+
+	var out bytes.Buffer
+	printWhyJSON([]models.Project{project}, &out)
+	compareGolden(t, filepath.Join(caseDir, "why-json.golden"), out.Bytes())
+}
+
+func TestWhyScopes(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-native")
+	mavenRepo, _ := buildRepositories(t, caseDir)
+	m := maven.Maven{
+		RootCtx:   testRootCtx(),
+		PomFile:   filepath.Join(caseDir, "pom.xml"),
+		MavenRepo: mavenRepo,
+		Native:    true,
+	}
+	projects := analyzeScopes(m, []string{"compile", "test"})
+	rootCtx = testRootCtx()
+	whyQuery = "com.example:core"
+
+	var tree bytes.Buffer
+	log.SetOutput(&tree)
+	defer log.SetOutput(os.Stderr)
+	printWhyScopes(projects)
+	compareGolden(t, filepath.Join(caseDir, "why-scopes-tree.golden"), tree.Bytes())
+
+	var out bytes.Buffer
+	printWhyScopesJSON(projects, &out)
+	compareGolden(t, filepath.Join(caseDir, "why-scopes-json.golden"), out.Bytes())
+
+	// The scopes are reported through the why command's own reporter
+	cmd := exec.Command(os.Args[0], "why", "com.example:core", "maven", "--native", "--repo", mavenRepo,
+		"--scopes", "compile,test", "--output", "json", filepath.Join(caseDir, "pom.xml"))
+	cmd.Env = append(os.Environ(), mainEnv+"=true")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Expected why to succeed with --scopes: %s", err)
+	}
+	compareGolden(t, filepath.Join(caseDir, "why-scopes-json.golden"), output)
+}
+
+func TestReverse(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-verbose")
+	project := analyzeCase(t, caseDir, true)
+	rootCtx.Reverse = true
+
+	var tree bytes.Buffer
+	log.SetOutput(&tree)
+	defer log.SetOutput(os.Stderr)
+	printTree(project)
+	compareGolden(t, filepath.Join(caseDir, "reverse-tree.golden"), tree.Bytes())
+
+	var out bytes.Buffer
+	printJSON(project, &out)
+	compareGolden(t, filepath.Join(caseDir, "reverse-json.golden"), out.Bytes())
+}
+
+func TestTop(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-verbose")
+	project := analyzeCase(t, caseDir, true)
+	rootCtx.Top = 5
+	rootCtx.TopBy = TopByExclusive
+
+	var tree bytes.Buffer
+	log.SetOutput(&tree)
+	defer log.SetOutput(os.Stderr)
+	printTopTable(project)
+	compareGolden(t, filepath.Join(caseDir, "top-tree.golden"), tree.Bytes())
+
+	var out bytes.Buffer
+	printTopJSON(project, &out)
+	compareGolden(t, filepath.Join(caseDir, "top-json.golden"), out.Bytes())
+}
+
+func TestShapeTree(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-simple")
+	project := analyzeCase(t, caseDir, false)
+
+	cases := map[string]func(ctx *models.RootCtx){
+		"sorted": func(ctx *models.RootCtx) {
+			ctx.Sort = SortByTotal
+			ctx.MaxDepth = 2
+			ctx.MinSizeBytes = 40000
+			ctx.Exclude = []string{"com.fasterxml.jackson.*:*"}
+		},
+		"include": func(ctx *models.RootCtx) {
+			ctx.Sort = SortByName
+			ctx.Include = []string{"*:jackson-databind", "joda-time:*"}
+		},
+		// Reversed, the same dependents show up under many dependencies
+		"collapse": func(ctx *models.RootCtx) {
+			ctx.Reverse = true
+			ctx.Collapse = true
+		},
+	}
+	for name, configure := range cases {
+		t.Run(name, func(t *testing.T) {
+			rootCtx = testRootCtx()
+			configure(&rootCtx)
+
+			var tree bytes.Buffer
+			log.SetOutput(&tree)
+			defer log.SetOutput(os.Stderr)
+			printTree(project)
+			compareGolden(t, filepath.Join(caseDir, fmt.Sprintf("%s-tree.golden", name)), tree.Bytes())
+		})
+	}
+}
+
+func TestExplorer(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-simple")
+	project := analyzeCase(t, caseDir, false)
+
+	// Each step is a screen after typing some keys
+	steps := []struct {
+		name string
+		keys string
+	}{
+		{"open", ""},
+		{"sort by total and expand", "ssg\x1b[C"},
+		{"search", "/httpcore\r"},
+		{"why", "w"},
+		{"jump to path and show file sizes", "\rt"},
+		{"expand everything", "E\x1b[6~"},
+	}
+	e := newExplorer(project)
+	var screens bytes.Buffer
+	for _, step := range steps {
+		for _, key := range parseKeys([]byte(step.keys)) {
+			e.handleKey(key)
+		}
+		fmt.Fprintf(&screens, "--- %s\n", step.name)
+		for _, line := range e.render(100, 14) {
+			fmt.Fprintln(&screens, strings.TrimRight(line, " "))
+		}
+	}
+	compareGolden(t, filepath.Join(caseDir, "explorer.golden"), screens.Bytes())
+}
+
+// The explorer keeps running until the user quits, so --strict has to fail
+// the run before it starts
+func TestExplorerStrict(t *testing.T) {
+	caseDir := filepath.Join("testdata", "gradle-edge")
+	_, gradleCache := buildRepositories(t, caseDir)
+	cmd := exec.Command(os.Args[0], "gradle", "--cmd", stubCommand(t, caseDir), "--cache", gradleCache,
+		"--tui", "--strict", "tests")
+	cmd.Env = append(os.Environ(), mainEnv+"=true")
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("Expected the analysis to fail, got:\n%s", output)
+	}
+	if !bytes.Contains(output, []byte("1 artifacts could not be found")) {
+		t.Errorf("Expected the unresolved artifacts to fail the run, got:\n%s", output)
+	}
+}
+
+func TestHTMLReport(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-simple")
+	project := analyzeCase(t, caseDir, false)
+
+	var out bytes.Buffer
+	printHTML(project, &out)
+	compareGolden(t, filepath.Join(caseDir, "html.golden"), out.Bytes())
+}
+
+func TestGraphExport(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-verbose")
+	project := analyzeCase(t, caseDir, true)
+
+	var dot, mermaid bytes.Buffer
+	printDot(project, &dot)
+	compareGolden(t, filepath.Join(caseDir, "graph-dot.golden"), dot.Bytes())
+	printMermaid(project, &mermaid)
+	compareGolden(t, filepath.Join(caseDir, "graph-mermaid.golden"), mermaid.Bytes())
+}
+
+func TestCycloneDX(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-verbose")
+	project := analyzeCase(t, caseDir, true)
+
+	var jsonOut, xmlOut bytes.Buffer
+	printCycloneDX(project, &jsonOut)
+	compareGolden(t, filepath.Join(caseDir, "cyclonedx-json.golden"), jsonOut.Bytes())
+	printCycloneDXXML(project, &xmlOut)
+	compareGolden(t, filepath.Join(caseDir, "cyclonedx-xml.golden"), xmlOut.Bytes())
+}
+
+func TestSPDX(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-verbose")
+	project := analyzeCase(t, caseDir, true)
+	os.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	t.Cleanup(func() { os.Unsetenv("SOURCE_DATE_EPOCH") })
+
+	var out bytes.Buffer
+	printSPDX(project, &out)
+	compareGolden(t, filepath.Join(caseDir, "spdx.golden"), out.Bytes())
+}
+
+// Reads back the SBOMs written by TestCycloneDX and TestSPDX, which should give
+// the tree they were written from without the entries Maven left out, as well
+// as SBOMs written by other tools
+func TestSBOMImport(t *testing.T) {
+	cases := []struct {
+		name  string
+		dir   string
+		file  string
+		sized bool
+	}{
+		{"cyclonedx-json", "maven-verbose", "cyclonedx-json.golden", true},
+		{"cyclonedx-xml", "maven-verbose", "cyclonedx-xml.golden", true},
+		{"spdx", "maven-verbose", "spdx.golden", true},
+		// Without the artifacts, sizes come from the SBOM itself
+		{"cyclonedx-properties", "maven-verbose", "cyclonedx-json.golden", false},
+		{"cyclonedx-maven-plugin", "sbom-cyclonedx-maven", "bom.json", true},
+		{"spdx-npm", "sbom-spdx-npm", "sbom.spdx.json", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			caseDir := filepath.Join("testdata", c.dir)
+			mavenRepo, _ := buildRepositories(t, caseDir)
+			npmCache := buildNpmCache(t, caseDir)
+			if !c.sized {
+				mavenRepo = t.TempDir()
+			}
+			s := SBOMImport{RootCtx: testRootCtx(), Repo: mavenRepo, NpmCache: npmCache}
+			project := s.Analyze(filepath.Join(caseDir, c.file))
+			rootCtx = testRootCtx()
+
+			var tree bytes.Buffer
+			log.SetOutput(&tree)
+			defer log.SetOutput(os.Stderr)
+			printTree(project)
+			compareGolden(t, filepath.Join(caseDir, "sbom-tree.golden"), tree.Bytes())
+		})
+	}
+}
+
+// Index entries written by other tools may have an integrity that can't be
+// used to find the content
+func TestNpmContentPathMalformed(t *testing.T) {
+	for _, integrity := range []string{"", " \t", "sha512", "sha512-not base64!"} {
+		if path := npmContentPath("cache", integrity); path != "" {
+			t.Errorf("Expected no content path for %q, got %s", integrity, path)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-simple")
+	mavenRepo, _ := buildRepositories(t, caseDir)
+	stub := stubCommand(t, caseDir)
+	os.Setenv(mainEnv, "true")
+	t.Cleanup(func() { os.Unsetenv(mainEnv) })
+
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "app", "pom.xml"), 0)
+	outside := t.TempDir()
+	createFile(t, filepath.Join(outside, "pom.xml"), 0)
+	s := newServer(os.Args[0], t.TempDir(), root)
+	s.toolArgs["maven"] = []string{"--cmd", stub, "--repo", mavenRepo}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	submit := func(request analysisRequest) (int, analysisResponse) {
+		data, _ := json.Marshal(request)
+		resp, err := http.Post(ts.URL+"/api/analyses", "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var submitted analysisResponse
+		if err := json.NewDecoder(resp.Body).Decode(&submitted); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, submitted
+	}
+
+	// Requests can only shape the analysis of projects in the root
+	rejected := []analysisRequest{
+		{Path: "app", Args: []string{"--cmd", "/bin/sh"}},
+		{Path: "app", Args: []string{"--define=exec.executable=/bin/sh"}},
+		{Path: "app", Args: []string{"--scope", "runtime", "/etc/passwd"}},
+		{Path: outside},
+		{Path: filepath.Join("..", filepath.Base(outside))},
+	}
+	for _, request := range rejected {
+		if status, _ := submit(request); status != http.StatusBadRequest {
+			t.Errorf("Expected a 400 for %v, got %d", request, status)
+		}
+	}
+
+	status, submitted := submit(analysisRequest{Path: "app", Args: []string{"--large-threshold", "1MB"}})
+	if status != http.StatusOK {
+		t.Fatalf("Analysis failed with %d: %s", status, submitted.Error)
+	}
+	if submitted.Tool != "maven" || submitted.Path != filepath.Join(s.root, "app", "pom.xml") {
+		t.Errorf("Analyzed %s with %s", submitted.Path, submitted.Tool)
+	}
+
+	get := func(path string) (*http.Response, []byte) {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	// The server serves the same JSON as sif -o json
+	_, body := get(submitted.JSON)
+	compareGolden(t, filepath.Join(caseDir, "json.golden"), body)
+
+	// The link for the project redirects to the report analyzed above
+	resp, body := get("/view?path=app&args=--large-threshold&args=1MB")
+	if resp.Request.URL.Path != submitted.HTML {
+		t.Errorf("Expected to be sent to %s, got %s", submitted.HTML, resp.Request.URL.Path)
+	}
+	if !bytes.Contains(body, []byte("<title>simple-pom 1.0.0")) {
+		t.Errorf("Expected an HTML report, got:\n%s", body)
+	}
+
+	if resp, _ := get("/api/analyses/unknown"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 for an unknown analysis, got %d", resp.StatusCode)
+	}
+
+	// Uploaded build files aren't run unless the server allows it
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "pom.xml")
+	part.Write([]byte("<project/>"))
+	writer.Close()
+	resp, err := http.Post(ts.URL+"/api/analyses", writer.FormDataContentType(), &form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a 400 for an uploaded POM, got %d", resp.StatusCode)
+	}
+
+	// Once there are too many analyses, the least recently used one is dropped
+	// along with its files
+	rawDir := filepath.Join(s.cacheDir, submitted.ID, "raw")
+	if _, err := os.Stat(rawDir); err != nil {
+		t.Fatalf("Expected the raw output to be saved: %s", err)
+	}
+	s.maxAnalyses = 1
+	if status, other := submit(analysisRequest{Path: "app", Args: []string{"--large-threshold", "2MB"}}); status != http.StatusOK {
+		t.Fatalf("Analysis failed with %d: %s", status, other.Error)
+	}
+	if resp, _ := get(submitted.JSON); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 for a dropped analysis, got %d", resp.StatusCode)
+	}
+	if _, err := os.Stat(filepath.Join(s.cacheDir, submitted.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected the files of a dropped analysis to be removed, got %v", err)
+	}
+}
+
+func TestMavenWrapperAndOptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the wrapper stub is a shell script")
+	}
+	caseDir := filepath.Join("testdata", "maven-json")
+	mavenRepo, _ := buildRepositories(t, caseDir)
+	stub := stubCommand(t, caseDir)
+
+	// A mvnw next to the POM should be picked up instead of mvn
+	projectDir := t.TempDir()
+	createFile(t, filepath.Join(projectDir, "pom.xml"), 0)
+	wrapper := filepath.Join(projectDir, "mvnw")
+	if err := ioutil.WriteFile(wrapper, []byte(fmt.Sprintf("#!/bin/sh\nexec %q \"$@\"\n", stub)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	settingsFile := filepath.Join(projectDir, "settings.xml")
+	createFile(t, settingsFile, 0)
+
+	rawDir := t.TempDir()
+	ctx := testRootCtx()
+	ctx.SaveRawDir = rawDir
+	m := maven.Maven{
+		RootCtx:      ctx,
+		PomFile:      filepath.Join(projectDir, "pom.xml"),
+		Scope:        "compile",
+		MavenRepo:    mavenRepo,
+		SettingsFile: settingsFile,
+		Profiles:     []string{"ci", "fast"},
+		Properties:   []string{"skipTests=true", "repo.password=hunter2"},
+		Offline:      true,
+	}
+	os.Setenv("MAVEN_OPTS", "-Xmx1g -Dhttp.proxyPassword=swordfish")
+	t.Cleanup(func() { os.Unsetenv("MAVEN_OPTS") })
+	assertGolden(t, caseDir, m.Analyze())
+
+	data, err := ioutil.ReadFile(filepath.Join(rawDir, "dependency-tree-json", "info.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info recording.Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	command := strings.Join(info.Command, " ")
+	if info.Command[0] != wrapper {
+		t.Errorf("expected %s to be run, got %s", wrapper, command)
+	}
+	for _, arg := range []string{"-P ci,fast", "-DskipTests=true", "--offline", "--settings " + settingsFile} {
+		if !strings.Contains(command, arg) {
+			t.Errorf("expected %q to be passed to Maven, got %s", arg, command)
+		}
+	}
+
+	// Credentials passed as properties aren't saved
+	for _, secret := range []string{"hunter2", "swordfish"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("expected %s to be redacted, got:\n%s", secret, data)
+		}
+	}
+	if !strings.Contains(command, "-Drepo.password=REDACTED") || info.Environment["MAVEN_OPTS"] != "-Xmx1g -Dhttp.proxyPassword=REDACTED" {
+		t.Errorf("expected credentials to be redacted, got:\n%s", data)
+	}
+}
+
+func TestMavenAnalyzeNative(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-native")
+	mavenRepo, _ := buildRepositories(t, caseDir)
+	m := maven.Maven{
+		RootCtx:   testRootCtx(),
+		PomFile:   filepath.Join(caseDir, "pom.xml"),
+		Scope:     "compile",
+		MavenRepo: mavenRepo,
+		Native:    true,
+	}
+	assertGolden(t, caseDir, m.Analyze())
+}
+
+// Artifacts missing from the local repository are sized from a remote one,
+// reached through a mirror with credentials from the settings file
+func TestMavenAnalyzeRemote(t *testing.T) {
+	caseDir := filepath.Join("testdata", "maven-simple")
+	remoteRepo, _ := buildRepositories(t, caseDir)
+	fileServer := http.FileServer(http.Dir(remoteRepo))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "sif" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	settingsFile := filepath.Join(dir, "settings.xml")
+	settings := fmt.Sprintf(`<settings>
+  <mirrors>
+    <mirror><id>internal</id><url>%s</url><mirrorOf>*</mirrorOf></mirror>
+  </mirrors>
+  <servers>
+    <server><id>internal</id><username>sif</username><password>secret</password></server>
+  </servers>
+</settings>`, server.URL)
+	if err := ioutil.WriteFile(settingsFile, []byte(settings), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A miss saved by an older version must be looked up again
+	cacheFile := filepath.Join(dir, "remote-sizes.json")
+	stale := fmt.Sprintf(`{"%s/org/slf4j/slf4j-api/1.7.29/slf4j-api-1.7.29.jar": -1}`, server.URL)
+	if err := ioutil.WriteFile(cacheFile, []byte(stale), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newMaven := func() maven.Maven {
+		return maven.Maven{
+			RootCtx:         testRootCtx(),
+			PomFile:         "tests/pom.xml",
+			Scope:           "compile",
+			MavenCommand:    stubCommand(t, caseDir),
+			MavenRepo:       filepath.Join(dir, "empty"),
+			RemoteRepo:      "https://repo.maven.apache.org/maven2",
+			RemoteRepoId:    "central",
+			SettingsFile:    settingsFile,
+			RemoteCacheFile: cacheFile,
+		}
+	}
+	m := newMaven()
+	assertGolden(t, caseDir, m.Analyze())
+
+	// A second run must be answered from the cache
+	server.Close()
+	m = newMaven()
+	assertGolden(t, caseDir, m.Analyze())
+}
+
+func TestGradleAnalyze(t *testing.T) {
+	for _, name := range []string{"gradle-simple", "gradle-edge", "gradle-init-script"} {
+		t.Run(name, func(t *testing.T) {
+			caseDir := filepath.Join("testdata", name)
+			_, gradleCache := buildRepositories(t, caseDir)
+			g := gradle.Gradle{
+				RootCtx:         testRootCtx(),
+				BuildGradleFile: "tests",
+				Configuration:   "runtimeClasspath",
+				GradleCommand:   stubCommand(t, caseDir),
+				GradleCache:     gradleCache,
+			}
+			assertGolden(t, caseDir, g.Analyze())
+		})
+	}
+}
+
+// The init script prints its report on a single line, which can be larger
+// than the lines a scanner reads by default
+func TestGradleAnalyzeLargeReport(t *testing.T) {
+	caseDir := filepath.Join("testdata", "gradle-init-script")
+	_, gradleCache := buildRepositories(t, caseDir)
+	stdout, err := ioutil.ReadFile(filepath.Join(caseDir, "init-script", "stdout.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := strings.Repeat(" ", 2*1024*1024)
+	stdout = bytes.Replace(stdout, []byte("\n{"), []byte("\n{"+padding), 1)
+
+	stubDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(stubDir, "init-script"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(stubDir, "init-script", "stdout.txt"), stdout, 0644); err != nil {
+		t.Fatal(err)
+	}
+	g := gradle.Gradle{
+		RootCtx:         testRootCtx(),
+		BuildGradleFile: "tests",
+		Configuration:   "runtimeClasspath",
+		GradleCommand:   stubCommand(t, stubDir),
+		GradleCache:     gradleCache,
+	}
+	assertGolden(t, caseDir, g.Analyze())
+}
+
+func TestGradleAnalyzeProjects(t *testing.T) {
+	caseDir := filepath.Join("testdata", "gradle-projects")
+	_, gradleCache := buildRepositories(t, caseDir)
+	g := gradle.Gradle{
+		RootCtx:         testRootCtx(),
+		BuildGradleFile: "tests",
+		Configuration:   "runtimeClasspath",
+		GradleCommand:   stubCommand(t, caseDir),
+		GradleCache:     gradleCache,
+		AllProjects:     true,
+	}
+	assertModulesGolden(t, caseDir, g.AnalyzeProjects())
+}
+
+// Parsing saved output must give the same result as running the build tool,
+// apart from details that only the build tool can provide
+func TestParseSavedOutput(t *testing.T) {
+	cases := []struct {
+		name   string
+		format string
+		output string
+	}{
+		{"maven-simple", FormatMavenTree, "dependency-tree"},
+		{"maven-edge", FormatMavenTree, "dependency-tree"},
+		{"maven-verbose", FormatMavenTree, "dependency-tree"},
+		{"gradle-simple", FormatGradleTree, "dependencies"},
+		{"gradle-edge", FormatGradleTree, "dependencies"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			caseDir := filepath.Join("testdata", c.name)
+			mavenRepo, gradleCache := buildRepositories(t, caseDir)
+			s := SavedOutput{
+				RootCtx:       testRootCtx(),
+				Format:        c.format,
+				Repo:          mavenRepo,
+				Configuration: "runtimeClasspath",
+			}
+			if c.format == FormatGradleTree {
+				s.Repo = gradleCache
+			}
+			project := s.Analyze(filepath.Join(caseDir, c.output, "stdout.txt"))
+			if c.format == FormatGradleTree {
+				// The Gradle dependencies report doesn't include the version
+				project.Version = "1.0.0"
+			}
+			assertGolden(t, caseDir, project)
+		})
+	}
+}
@@ -1,23 +1,26 @@
 package maven
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"sif/models"
+	"sif/recording"
 	"strings"
-	"time"
 )
 
 var (
 	regexProjectDetails    = regexp.MustCompile("\\[INFO\\] Building ([^\\s]+) ([^\\s]+)")
 	regexErrNonreadablePom = regexp.MustCompile(".* Non-readable POM.*")
 	regexErrReactorPom     = regexp.MustCompile(".*Reactor Build Order:.*")
-	dependencyRegex        = regexp.MustCompile("(\\|\\s\\s)*(\\+-|\\\\-) (.+)")
+	dependencyRegex        = regexp.MustCompile("^([|\\s]*)(\\+-|\\\\-) (.+)")
+	treeStartRegex         = regexp.MustCompile("\\[INFO\\] --- (maven-)?dependency(-plugin)?:.+:tree .*")
+	treeEndRegex           = regexp.MustCompile("\\[INFO\\] (BUILD SUCCESS|-{20,}).*")
+	omittedConflictRegex   = regexp.MustCompile("omitted for conflict with ([^;)\\s]+)")
+	versionManagedRegex    = regexp.MustCompile("version managed from ([^;)\\s]+)")
 )
 
 type Maven struct {
@@ -27,6 +30,26 @@ type Maven struct {
 	MavenCommand string
 	MavenRepo    string
 	ChildModule  string
+	AllModules   bool
+	Native       bool
+	Verbose      bool
+
+	// Options passed through to Maven
+	Profiles   []string
+	Properties []string
+	Offline    bool
+
+	// Optional remote repository used to size artifacts missing from MavenRepo
+	RemoteRepo      string
+	RemoteRepoId    string
+	SettingsFile    string
+	RemoteCacheFile string
+	remote          *remoteRepository
+
+	// Modules of the build being analyzed, by coordinates, used by the native resolver
+	reactor map[string]string
+	// A failed run of the build, kept so the tree isn't asked for again
+	treeFailure *recording.Result
 }
 
 func (m *Maven) describeError(errMsg string) {
@@ -40,72 +63,190 @@ func (m *Maven) describeError(errMsg string) {
 }
 
 func (m *Maven) checkMultiModulePom(output string) {
-	if m.ChildModule == "" && regexErrReactorPom.MatchString(output) {
-		log.Fatalf("Multimodule POM detected with no selected child POM. Please select one with the --child option")
+	if m.ChildModule == "" && !m.AllModules && regexErrReactorPom.MatchString(output) {
+		log.Fatalf("Multimodule POM detected with no selected child POM. Please select one with the --child option, or use --all-modules")
 	}
 }
 
+// Finds the artifact file for a dependency and records its size. If it isn't
+// in the local repository, we check whether it was relocated and then try the
+// remote repository, if there is one. The places searched are kept so they can
+// be reported when the artifact can't be found.
 func (m *Maven) determineFileSize(dep *models.Dependency) models.Dependency {
-	groupPath := strings.ReplaceAll(dep.GroupId, ".", "/")
-	file := fmt.Sprintf("%s/%s/%s/%s/%s-%s.%s",
-		m.MavenRepo,
-		groupPath,
-		dep.ArtifactId,
-		dep.Version,
-		dep.ArtifactId,
-		dep.Version,
-		dep.Extension)
-	stats, err := os.Stat(file)
-	if err != nil {
-		dep.Size = 0
-	} else {
+	dep.Size = 0
+	dep.File = ""
+	file := m.repoPath(dep.GroupId, dep.ArtifactId, dep.Version, dep.FileName())
+	dep.SearchedPaths = []string{file}
+	if stats, err := os.Stat(file); err == nil {
 		dep.Size = uint64(stats.Size())
+		dep.Status = models.StatusFound
+		dep.File = file
+		return *dep
+	}
+
+	pomFile := m.repoPath(dep.GroupId, dep.ArtifactId, dep.Version, fmt.Sprintf("%s-%s.pom", dep.ArtifactId, dep.Version))
+	p, pomErr := readPom(pomFile)
+	if pomErr == nil && p.Relocation != nil {
+		relocated := *dep
+		relocated.GroupId = valueOr(p.Relocation.GroupId, dep.GroupId)
+		relocated.ArtifactId = valueOr(p.Relocation.ArtifactId, dep.ArtifactId)
+		relocated.Version = valueOr(p.Relocation.Version, dep.Version)
+		relocatedFile := m.repoPath(relocated.GroupId, relocated.ArtifactId, relocated.Version, relocated.FileName())
+		dep.SearchedPaths = append(dep.SearchedPaths, relocatedFile)
+		dep.RelocatedTo = fmt.Sprintf("%s:%s:%s", relocated.GroupId, relocated.ArtifactId, relocated.Version)
+		if stats, err := os.Stat(relocatedFile); err == nil {
+			dep.Size = uint64(stats.Size())
+			dep.Status = models.StatusRelocated
+			dep.File = relocatedFile
+			return *dep
+		}
+	}
+
+	if m.RemoteRepo != "" {
+		if m.remote == nil {
+			m.remote = m.newRemoteRepository()
+		}
+		artifactUrl := m.remote.artifactUrl(dep)
+		dep.SearchedPaths = append(dep.SearchedPaths, artifactUrl)
+		if size := m.remote.fileSize(artifactUrl); size >= 0 {
+			dep.Size = uint64(size)
+			dep.Status = models.StatusFound
+			return *dep
+		}
+	}
+
+	if pomErr == nil {
+		dep.Status = models.StatusPomOnly
+	} else {
+		dep.Status = models.StatusMissing
 	}
 	return *dep
 }
 
-func (m *Maven) parseMavenCoordinates(entry string) models.Dependency {
+// DetermineFileSize sizes a dependency found somewhere other than the output of
+// Maven, such as an SBOM, from the local repository
+func (m *Maven) DetermineFileSize(dep *models.Dependency) models.Dependency {
+	return m.determineFileSize(dep)
+}
+
+func valueOr(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func (m *Maven) saveRemoteCache() {
+	if m.remote != nil {
+		m.remote.saveCache()
+	}
+}
+
+// Parses a tree entry into a dependency. Returns false for entries that a
+// verbose tree shows for information only and that shouldn't be kept.
+func (m *Maven) parseMavenCoordinates(entry string) (models.Dependency, bool) {
 	r := dependencyRegex.FindStringSubmatch(entry)
-	depString := r[3]
+
+	// Entries may be followed by annotations such as "(optional)", and have
+	// one of these formats depending on whether the artifact has a classifier:
+	//
+	//	<groupId>:<artifactId>:<type>:<version>:<scope>
+	//	<groupId>:<artifactId>:<type>:<classifier>:<version>:<scope>
+	//
+	// A verbose tree also shows entries Maven left out of the classpath, in
+	// parentheses with the reason after the coordinates:
+	//
+	//	(<coordinates> - omitted for conflict with <version>)
+	//	(<coordinates> - omitted for duplicate)
+	//	<coordinates> (version managed from <version>)
+	content := r[3]
+	omitted := strings.HasPrefix(content, "(")
+	if omitted {
+		content = strings.TrimSuffix(strings.TrimPrefix(content, "("), ")")
+		content = strings.Replace(content, " - ", " ", 1)
+	}
+	depString := strings.Fields(content)[0]
+	annotations := strings.TrimPrefix(content, depString)
+
 	split := strings.Split(depString, ":")
-	return m.determineFileSize(&models.Dependency{
+	dep := models.Dependency{
 		GroupId:    split[0],
 		ArtifactId: split[1],
-		Extension:  split[2],
+		Extension:  TypeExtension(split[2]),
 		Version:    split[3],
 		Size:       0,
-	})
+	}
+	if len(split) >= 6 {
+		dep.Classifier = split[3]
+		dep.Version = split[4]
+	}
+	if managed := versionManagedRegex.FindStringSubmatch(annotations); managed != nil {
+		dep.VersionManagedFrom = managed[1]
+	}
+
+	// Only conflicts say anything about mediation. Duplicates and cycles are
+	// already in the tree elsewhere.
+	if omitted {
+		conflict := omittedConflictRegex.FindStringSubmatch(annotations)
+		if conflict == nil {
+			return dep, false
+		}
+		dep.OmittedForConflictWith = conflict[1]
+		dep.Status = models.StatusOmitted
+		return dep, true
+	}
+	return m.determineFileSize(&dep), true
 }
 
 func (m *Maven) parseProjectDetails(output string) (string, string) {
 	var r = regexProjectDetails.FindStringSubmatch(output)
+	if r == nil {
+		log.Warn("Unable to determine project name and version from Maven output")
+		return "", ""
+	}
 	return r[1], r[2]
 }
 
-func (m *Maven) parseOutputTree(output string) []models.Dependency {
-	// Remove everything except the tree output. The first line
-	// that matches this regex is where we should begin.
-	var lines = strings.Split(output, "\n")
-	var startLine = 0
-	var endLine = 0
-	var startRegex = regexp.MustCompile("\\[INFO\\] --- maven-dependency-plugin:.+:tree .*")
-	var endRegex = regexp.MustCompile("\\[INFO\\] BUILD SUCCESS.*")
+// Finds each dependency tree in the output. A tree starts after a dependency
+// plugin banner and ends at the first separator line after it. Multi-module
+// builds print one tree per module.
+func findTreeSections(lines []string) [][2]int {
+	var sections [][2]int
 	for lineNum, line := range lines {
-		if startRegex.MatchString(line) {
-			startLine = lineNum
+		if treeStartRegex.MatchString(line) {
+			sections = append(sections, [2]int{lineNum, len(lines)})
+		} else if len(sections) > 0 && sections[len(sections)-1][1] == len(lines) && treeEndRegex.MatchString(line) {
+			sections[len(sections)-1][1] = lineNum
 		}
-		if endRegex.MatchString(line) {
-			endLine = lineNum - 1
+	}
+	return sections
+}
+
+// Removes the [INFO] bit from each line of a tree
+func treeEntries(lines []string, section [2]int) []string {
+	var entries []string
+	for i := section[0] + 1; i < section[1]; i++ {
+		l := strings.SplitN(strings.TrimRight(lines[i], "\r"), "[INFO] ", 2)
+		if len(l) == 2 {
+			entries = append(entries, l[1])
 		}
 	}
+	return entries
+}
 
-	// Extract the tree from the output, removing the [INFO] bit
-	var depTreeEntries []string
-	for i := startLine; i < endLine; i++ {
-		l := strings.Split(lines[i], "[INFO] ")
-		depTreeEntries = append(depTreeEntries, l[1])
+func (m *Maven) parseOutputTree(output string) []models.Dependency {
+	// Remove everything except the tree output. If there is more than one
+	// tree, the last one is for the module we're interested in.
+	var lines = strings.Split(output, "\n")
+	sections := findTreeSections(lines)
+	if len(sections) == 0 {
+		log.Warn("No dependency tree found in Maven output")
+		return nil
 	}
+	return m.parseTreeEntries(treeEntries(lines, sections[len(sections)-1]))
+}
 
+func (m *Maven) parseTreeEntries(depTreeEntries []string) []models.Dependency {
 	// The dependency tree output is in ordered form, so extracting is easy. We
 	// keep track of each top-level dep and for each child entry, we just find
 	// the last entry in the toplevel and walk down its children, using the
@@ -117,92 +258,216 @@ func (m *Maven) parseOutputTree(output string) []models.Dependency {
 		//
 		//	- +-	: 	Indicates a top-level dependency
 		//	- \-	: 	Indicates the last top-level dependency
-		//	- |  +- : 	Indicates a child dependency. The depth is equal to the number of | chars
-		//	- |  \-	:	Indicates the last entry at the current level. The depth is equal to the number of | chars
-		if dependencyRegex.MatchString(entry) {
-			// Count the pipes
-			depth := strings.Count(entry, "|  ")
-			if depth > 0 {
-				// We are mutating array contents, so we can't use normal assignment or Go will
-				// transparently copy the data
-				curr := &dependencies
-				for i := 1; i < depth-1; i++ {
-					curr = &(*curr)[len(*curr)-1].Children
-				}
-
-				var parent = &(*curr)[len(*curr)-1]
-				parent.Children = append(parent.Children, m.parseMavenCoordinates(entry))
-			} else {
-				dependencies = append(dependencies, m.parseMavenCoordinates(entry))
-			}
+		//	- |  +- : 	Indicates a child dependency
+		//	- |  \-	:	Indicates the last entry at the current level
+		//
+		// Each level is indented by three characters, which are either "|  " or
+		// blank if the parent was the last entry at its own level.
+		r := dependencyRegex.FindStringSubmatch(entry)
+		if r == nil {
+			continue
+		}
+		depth := len(r[1]) / 3
+
+		// We are mutating array contents, so we can't use normal assignment or Go will
+		// transparently copy the data
+		curr := &dependencies
+		for i := 0; i < depth && len(*curr) > 0; i++ {
+			curr = &(*curr)[len(*curr)-1].Children
+		}
+		dep, ok := m.parseMavenCoordinates(entry)
+		if !ok {
+			continue
 		}
+		*curr = append(*curr, dep)
 	}
 
 	return dependencies
 }
 
+// Builds a project for each module in the output of a multi-module build. The
+// first line of each tree holds the module's own coordinates:
+//
+//	<groupId>:<artifactId>:<packaging>:<version>
+func (m *Maven) parseModules(output string) []models.Project {
+	var lines = strings.Split(output, "\n")
+	var projects []models.Project
+	for _, section := range findTreeSections(lines) {
+		entries := treeEntries(lines, section)
+		if len(entries) == 0 {
+			continue
+		}
+		coords := strings.Split(strings.TrimSpace(entries[0]), ":")
+		if len(coords) < 4 {
+			log.Warnf("Unable to determine module from dependency tree root: %s", entries[0])
+			continue
+		}
+		projects = append(projects, models.Project{
+			Name:         coords[1],
+			Version:      coords[len(coords)-1],
+			Dependencies: m.parseTreeEntries(entries[1:]),
+		})
+	}
+	return projects
+}
+
 func (m *Maven) Analyze() models.Project {
-	if m.RootCtx.LogLevel == "DEBUG" {
-		log.Debug("Logging Maven command output")
+	defer m.saveRemoteCache()
+	if m.Native {
+		m.checkNativeOptions()
+		return m.analyzeNative()
 	}
+	m.prepareTreeRun()
+	if roots, ok := m.runStructuredTree(); ok {
+		return m.toProject(roots[len(roots)-1])
+	}
+	return m.Parse(m.runDependencyTree())
+}
 
-	var args []string
-	if m.ChildModule == "" {
-		log.Infof("Running Maven command (%s)", m.MavenCommand)
-		args = []string{
-			"dependency:tree",
-			"-f",
-			m.PomFile,
-			fmt.Sprintf("-Dscope=%s", m.Scope),
+// AnalyzeModules builds a project for every module of a multi-module build
+// with a single Maven run. The build is compiled first so that modules which
+// depend on each other can be resolved from the reactor.
+func (m *Maven) AnalyzeModules() []models.Project {
+	defer m.saveRemoteCache()
+	if m.Native {
+		m.checkNativeOptions()
+		return m.analyzeNativeModules()
+	}
+	m.prepareTreeRun()
+	if roots, ok := m.runStructuredTree(); ok {
+		var projects []models.Project
+		for _, root := range roots {
+			projects = append(projects, m.toProject(root))
 		}
-	} else {
+		return projects
+	}
+	return m.ParseModules(m.runDependencyTree())
+}
+
+// ParseModules builds a project for each module in previously captured
+// dependency:tree output of a multi-module build
+func (m *Maven) ParseModules(output string) []models.Project {
+	defer m.saveRemoteCache()
+	projects := m.parseModules(output)
+	if len(projects) == 0 {
+		log.Fatalf("No modules found in Maven output")
+	}
+	return projects
+}
+
+// Returns the arguments used to run dependency:tree for the selected modules.
+// Modules other than the root have to be compiled so that modules which depend
+// on each other can be resolved from the reactor.
+func (m *Maven) treeArgs() []string {
+	args := []string{
+		"dependency:tree",
+		"-f",
+		m.PomFile,
+		fmt.Sprintf("-Dscope=%s", m.Scope),
+	}
+	if m.AllModules || m.ChildModule != "" {
+		args = append([]string{"compile"}, args...)
+	}
+	if m.ChildModule != "" {
+		args = append(args, "-pl", m.ChildModule, "-am")
+	}
+	if m.Verbose {
+		args = append(args, "-Dverbose")
+	}
+	return append(args, m.passthroughArgs()...)
+}
+
+// Returns the options given on the command line that Maven should see. The
+// settings file is only passed if there is one, since Maven fails otherwise.
+func (m *Maven) passthroughArgs() []string {
+	var args []string
+	if len(m.Profiles) > 0 {
+		args = append(args, "-P", strings.Join(m.Profiles, ","))
+	}
+	for _, property := range m.Properties {
+		args = append(args, fmt.Sprintf("-D%s", property))
+	}
+	if m.Offline {
+		args = append(args, "--offline")
+	}
+	if _, err := os.Stat(m.SettingsFile); m.SettingsFile != "" && err == nil {
+		args = append(args, "--settings", m.SettingsFile)
+	}
+	return args
+}
+
+// Search for the Maven executable to use. A mvnw/mvnw.cmd wrapper next to the
+// POM is preferred, otherwise "mvn" is assumed to be on the PATH.
+func (m *Maven) findMavenExecutable() string {
+	log.Debugf("Searching for maven executable")
+	projectDir := m.PomFile
+	if f, err := os.Stat(m.PomFile); err == nil && !f.IsDir() {
+		projectDir = filepath.Dir(m.PomFile)
+	}
+
+	wrapper := "mvnw"
+	if runtime.GOOS == "windows" {
+		wrapper = "mvnw.cmd"
+	}
+	bin := filepath.Join(projectDir, wrapper)
+	if f, err := os.Stat(bin); err == nil && !f.IsDir() {
+		log.Debugf("Found %s to run build", bin)
+		return bin
+	}
+
+	log.Debugf("No wrapper found, assuming that mvn is available on the PATH")
+	return "mvn"
+}
+
+func (m *Maven) prepareTreeRun() {
+	if m.RootCtx.LogLevel == "DEBUG" {
+		log.Debug("Logging Maven command output")
+	}
+	if m.MavenCommand == "" && m.RootCtx.ReplayDir == "" {
+		m.MavenCommand = m.findMavenExecutable()
+	}
+	if m.AllModules {
+		log.Info("Compiling project. Maven requires this when dealing with multiple modules.")
+	} else if m.ChildModule != "" {
 		log.Info("Compiling project. Maven requires this when dealing with child modules.")
-		args = []string{
-			"compile",
-			"dependency:tree",
-			"-f",
-			m.PomFile,
-			fmt.Sprintf("-Dscope=%s", m.Scope),
-			"-pl",
-			m.ChildModule,
-			"-am",
-		}
+	} else {
+		log.Infof("Running Maven command (%s)", m.MavenCommand)
 	}
+}
 
-	// Run dependency:tree tool
-	var out bytes.Buffer
-	cmd := exec.Command(m.MavenCommand, args...)
-	stdout, err := cmd.StdoutPipe()
-	scanner := bufio.NewScanner(stdout)
-	running := true
-
-	go func() {
-		for scanner.Scan() {
-			line := scanner.Text()
-			log.Debug(line)
-			out.WriteString(line)
-			out.Write([]byte("\n"))
-		}
-		if err := scanner.Err(); err != nil {
-			log.Error(os.Stderr, "reading standard input:", err)
-		}
-		running = false
-	}()
+// The native resolver doesn't evaluate profiles, so warn that they won't
+// change anything
+func (m *Maven) checkNativeOptions() {
+	if len(m.Profiles) > 0 {
+		log.Warnf("Profiles are not supported with --native and will be ignored: %s", strings.Join(m.Profiles, ","))
+	}
+}
 
-	err = cmd.Run()
+func (m *Maven) recorder() recording.Recorder {
+	return recording.Recorder{SaveDir: m.RootCtx.SaveRawDir, ReplayDir: m.RootCtx.ReplayDir}
+}
 
-	// Wait for the scanner to finish processing the output
-	for running {
-		time.Sleep(25 * time.Millisecond)
+func (m *Maven) runDependencyTree() string {
+	if m.treeFailure != nil {
+		log.Error(m.treeFailure.Err)
+		m.describeError(m.treeFailure.Stdout + m.treeFailure.Stderr)
 	}
 
-	var output = out.String()
-	if err != nil {
-		log.Error(err)
-		m.describeError(output)
+	// Run dependency:tree tool
+	recorder := m.recorder()
+	result := recorder.Run("dependency-tree", m.MavenCommand, m.treeArgs()...)
+	output := result.Stdout
+	if result.Err != nil {
+		log.Error(result.Err)
+		m.describeError(output + result.Stderr)
 	}
+	return output
+}
 
-	// Parse output
+// Parse builds a project from previously captured dependency:tree output. No
+// Maven command is run; artifact sizes are resolved against MavenRepo.
+func (m *Maven) Parse(output string) models.Project {
+	defer m.saveRemoteCache()
 	m.checkMultiModulePom(output)
 	deps := m.parseOutputTree(output)
 	name, version := m.parseProjectDetails(output)
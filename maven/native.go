@@ -0,0 +1,251 @@
+package maven
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"sif/models"
+	"strings"
+)
+
+// The scopes of direct dependencies that are included for each scope that can
+// be selected with --scope
+var nativeScopes = map[string][]string{
+	"compile":  {"compile", "provided", "system"},
+	"provided": {"provided"},
+	"runtime":  {"compile", "runtime"},
+	"test":     {"compile", "provided", "runtime", "system", "test"},
+}
+
+type nativeNode struct {
+	dep        pomDependency
+	scope      string
+	exclusions []pomExclusion
+	children   []*nativeNode
+}
+
+// Determines the scope of a transitive dependency based on the scope of the
+// dependency that pulled it in. An empty result means it is not inherited.
+func transitiveScope(parentScope string, childScope string) string {
+	if childScope != "compile" && childScope != "runtime" {
+		return ""
+	}
+	switch parentScope {
+	case "compile":
+		return childScope
+	case "provided", "runtime", "test":
+		return parentScope
+	default:
+		return ""
+	}
+}
+
+func isExcluded(dep pomDependency, exclusions []pomExclusion) bool {
+	for _, e := range exclusions {
+		if (e.GroupId == "*" || e.GroupId == dep.GroupId) && (e.ArtifactId == "*" || e.ArtifactId == dep.ArtifactId) {
+			return true
+		}
+	}
+	return false
+}
+
+// Maven can't resolve a version range without repository metadata, so we use
+// the lower bound of the range and hope that it's been downloaded
+func resolveVersion(dep pomDependency) string {
+	version := dep.Version
+	if strings.HasPrefix(version, "[") || strings.HasPrefix(version, "(") {
+		bound := strings.Trim(strings.Split(version, ",")[0], "[]() ")
+		log.Warnf("Using %s for version range %s of %s:%s", bound, version, dep.GroupId, dep.ArtifactId)
+		return bound
+	}
+	return version
+}
+
+// Finds the POM to resolve. If a child module was selected, it is looked up
+// relative to the directory of the main POM.
+func (m *Maven) nativePomFile() string {
+	if m.ChildModule == "" {
+		return m.PomFile
+	}
+	if strings.HasPrefix(m.ChildModule, ":") {
+		log.Fatalf("Selecting a child module by artifactId is not supported with --native, use its path instead")
+	}
+	return filepath.Join(filepath.Dir(m.PomFile), m.ChildModule, "pom.xml")
+}
+
+// Loads the effective POM for a dependency from the local repository. Results
+// are cached since the same POMs (particularly parents) come up repeatedly.
+func (m *Maven) loadDependencyPom(dep pomDependency, cache map[string]*effectivePom) *effectivePom {
+	key := fmt.Sprintf("%s:%s:%s", dep.GroupId, dep.ArtifactId, dep.Version)
+	if eff, ok := cache[key]; ok {
+		return eff
+	}
+	var p *pom
+	var err error
+	if file, ok := m.reactor[key]; ok {
+		p, err = readPom(file)
+	} else {
+		p, err = m.readRepoPom(dep.GroupId, dep.ArtifactId, dep.Version)
+	}
+	var eff *effectivePom
+	if err != nil {
+		log.Debugf("Unable to read POM for %s, its dependencies will not be included: %s", key, err)
+	} else if eff, err = m.buildEffectivePom(p, map[string]bool{}); err != nil {
+		log.Warnf("Unable to resolve POM for %s, its dependencies will not be included: %s", key, err)
+	}
+	cache[key] = eff
+	return eff
+}
+
+// Resolves the dependency tree directly from the POM and the local repository
+// without running Maven. The tree is walked breadth-first so that Maven's
+// nearest-wins mediation falls out naturally: the first time a dependency is
+// seen is the closest it will ever be to the root, and ties go to whichever
+// was declared first.
+func (m *Maven) analyzeNative() models.Project {
+	return m.resolveNative(m.nativePomFile())
+}
+
+// Resolves every module listed in the POM, and in turn the modules they list.
+// Modules in the build are resolved from their own POMs rather than the local
+// repository, so they don't need to have been installed.
+func (m *Maven) analyzeNativeModules() []models.Project {
+	var pomFiles []string
+	m.reactor = map[string]string{}
+	var collect func(file string)
+	collect = func(file string) {
+		p, err := readPom(file)
+		if err != nil {
+			log.Fatalf("Unable to read module POM %s: %s", file, err)
+		}
+		pomFiles = append(pomFiles, file)
+		m.reactor[fmt.Sprintf("%s:%s:%s", p.groupId(), p.ArtifactId, p.version())] = file
+		for _, module := range p.Modules {
+			collect(filepath.Join(filepath.Dir(file), module, "pom.xml"))
+		}
+	}
+	collect(m.PomFile)
+
+	var projects []models.Project
+	for _, file := range pomFiles {
+		projects = append(projects, m.resolveNative(file))
+	}
+	return projects
+}
+
+func (m *Maven) resolveNative(pomFile string) models.Project {
+	log.Infof("Resolving dependencies of %s from %s", pomFile, m.MavenRepo)
+
+	p, err := readPom(pomFile)
+	if err != nil {
+		log.Fatalf("POM was not found at %s: %s", pomFile, err)
+	}
+	root, err := m.buildEffectivePom(p, map[string]bool{})
+	if err != nil {
+		log.Fatalf("Unable to resolve POM: %s", err)
+	}
+
+	allowedScopes, ok := nativeScopes[m.Scope]
+	if !ok {
+		log.Fatalf("Unknown scope: %s", m.Scope)
+	}
+	isAllowed := func(scope string) bool {
+		for _, s := range allowedScopes {
+			if s == scope {
+				return true
+			}
+		}
+		return false
+	}
+
+	resolved := map[string]bool{}
+	cache := map[string]*effectivePom{}
+	var topLevel []*nativeNode
+	var queue []*nativeNode
+	for _, dep := range root.Dependencies {
+		if dep.Scope == "" {
+			dep.Scope = "compile"
+		}
+		if !isAllowed(dep.Scope) || resolved[dep.managementKey()] {
+			continue
+		}
+		resolved[dep.managementKey()] = true
+		dep.Version = resolveVersion(dep)
+		node := &nativeNode{dep: dep, scope: dep.Scope, exclusions: dep.Exclusions}
+		topLevel = append(topLevel, node)
+		queue = append(queue, node)
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node.scope == "system" {
+			continue
+		}
+
+		eff := m.loadDependencyPom(node.dep, cache)
+		if eff == nil {
+			continue
+		}
+		for _, dep := range eff.Dependencies {
+			if dep.Optional == "true" || isExcluded(dep, node.exclusions) {
+				continue
+			}
+			if dep.Scope == "" {
+				dep.Scope = "compile"
+			}
+
+			// The root project's dependency management overrides whatever the
+			// dependency itself declared
+			if managed, ok := root.Managed[dep.managementKey()]; ok {
+				if managed.Version != "" {
+					dep.Version = managed.Version
+				}
+				if managed.Scope != "" && dep.Scope != "test" && dep.Scope != "provided" {
+					dep.Scope = managed.Scope
+				}
+			}
+
+			scope := transitiveScope(node.scope, dep.Scope)
+			if scope == "" || !isAllowed(scope) || resolved[dep.managementKey()] {
+				continue
+			}
+			resolved[dep.managementKey()] = true
+			dep.Version = resolveVersion(dep)
+
+			var exclusions []pomExclusion
+			exclusions = append(exclusions, node.exclusions...)
+			exclusions = append(exclusions, dep.Exclusions...)
+			child := &nativeNode{dep: dep, scope: scope, exclusions: exclusions}
+			node.children = append(node.children, child)
+			queue = append(queue, child)
+		}
+	}
+
+	name := root.ArtifactId
+	if root.Name != "" {
+		name = root.interpolate(root.Name)
+	}
+	return models.Project{
+		Name:         name,
+		Version:      root.Version,
+		Dependencies: m.toDependencies(topLevel),
+	}
+}
+
+func (m *Maven) toDependencies(nodes []*nativeNode) []models.Dependency {
+	var deps []models.Dependency
+	for _, node := range nodes {
+		dep := m.determineFileSize(&models.Dependency{
+			GroupId:    node.dep.GroupId,
+			ArtifactId: node.dep.ArtifactId,
+			Version:    node.dep.Version,
+			Classifier: node.dep.Classifier,
+			Extension:  node.dep.extension(),
+			Size:       0,
+		})
+		dep.Children = m.toDependencies(node.children)
+		deps = append(deps, dep)
+	}
+	return deps
+}
@@ -0,0 +1,375 @@
+package maven
+
+import (
+	"encoding/xml"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var regexProperty = regexp.MustCompile("\\$\\{([^}]+)\\}")
+
+type pomParent struct {
+	GroupId      string  `xml:"groupId"`
+	ArtifactId   string  `xml:"artifactId"`
+	Version      string  `xml:"version"`
+	RelativePath *string `xml:"relativePath"`
+}
+
+type pomExclusion struct {
+	GroupId    string `xml:"groupId"`
+	ArtifactId string `xml:"artifactId"`
+}
+
+type pomRelocation struct {
+	GroupId    string `xml:"groupId"`
+	ArtifactId string `xml:"artifactId"`
+	Version    string `xml:"version"`
+}
+
+type pomDependency struct {
+	GroupId    string         `xml:"groupId"`
+	ArtifactId string         `xml:"artifactId"`
+	Version    string         `xml:"version"`
+	Type       string         `xml:"type"`
+	Classifier string         `xml:"classifier"`
+	Scope      string         `xml:"scope"`
+	Optional   string         `xml:"optional"`
+	Exclusions []pomExclusion `xml:"exclusions>exclusion"`
+}
+
+// Identifies a dependency for the purposes of dependency management. Maven
+// considers the type and classifier part of the key as well.
+func (d *pomDependency) managementKey() string {
+	return fmt.Sprintf("%s:%s:%s:%s", d.GroupId, d.ArtifactId, d.extension(), d.Classifier)
+}
+
+func (d *pomDependency) extension() string {
+	return TypeExtension(d.Type)
+}
+
+// Maps a dependency type to the extension of the file it refers to. Most
+// types are just jars with special handling in a particular plugin.
+func TypeExtension(depType string) string {
+	switch depType {
+	case "", "jar", "test-jar", "bundle", "maven-plugin", "ejb", "ejb-client", "java-source", "javadoc":
+		return "jar"
+	default:
+		return depType
+	}
+}
+
+// The properties section is a free-form list of elements, so it needs to be
+// unmarshalled by hand
+type pomProperties map[string]string
+
+func (p *pomProperties) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	*p = pomProperties{}
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			var value string
+			if err := d.DecodeElement(&value, &t); err != nil {
+				return err
+			}
+			(*p)[t.Name.Local] = strings.TrimSpace(value)
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+type pom struct {
+	GroupId              string          `xml:"groupId"`
+	ArtifactId           string          `xml:"artifactId"`
+	Version              string          `xml:"version"`
+	Name                 string          `xml:"name"`
+	Packaging            string          `xml:"packaging"`
+	Parent               *pomParent      `xml:"parent"`
+	Properties           pomProperties   `xml:"properties"`
+	DependencyManagement []pomDependency `xml:"dependencyManagement>dependencies>dependency"`
+	Dependencies         []pomDependency `xml:"dependencies>dependency"`
+	Modules              []string        `xml:"modules>module"`
+	Relocation           *pomRelocation  `xml:"distributionManagement>relocation"`
+
+	file string
+}
+
+func readPom(file string) (*pom, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var p pom
+	if err := xml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", file, err)
+	}
+	p.file = file
+	if p.Properties == nil {
+		p.Properties = pomProperties{}
+	}
+	return &p, nil
+}
+
+// Returns the path to an artifact in the local repository
+func (m *Maven) repoPath(groupId string, artifactId string, version string, fileName string) string {
+	return filepath.Join(m.MavenRepo, strings.ReplaceAll(groupId, ".", "/"), artifactId, version, fileName)
+}
+
+func (m *Maven) readRepoPom(groupId string, artifactId string, version string) (*pom, error) {
+	return readPom(m.repoPath(groupId, artifactId, version, fmt.Sprintf("%s-%s.pom", artifactId, version)))
+}
+
+// Loads a parent POM. Like Maven, we first try the relative path (which defaults
+// to the parent directory) and fall back to the local repository if the POM
+// found there isn't the one that was asked for.
+func (m *Maven) readParentPom(child *pom) (*pom, error) {
+	parent := child.Parent
+	if child.file != "" {
+		relativePath := "../pom.xml"
+		if parent.RelativePath != nil {
+			relativePath = *parent.RelativePath
+		}
+		if relativePath != "" {
+			file := filepath.Join(filepath.Dir(child.file), relativePath)
+			if f, err := os.Stat(file); err == nil && f.IsDir() {
+				file = filepath.Join(file, "pom.xml")
+			}
+			if p, err := readPom(file); err == nil && p.ArtifactId == parent.ArtifactId && p.groupId() == parent.GroupId {
+				return p, nil
+			}
+		}
+	}
+	return m.readRepoPom(parent.GroupId, parent.ArtifactId, parent.Version)
+}
+
+func (p *pom) groupId() string {
+	if p.GroupId == "" && p.Parent != nil {
+		return p.Parent.GroupId
+	}
+	return p.GroupId
+}
+
+func (p *pom) version() string {
+	if p.Version == "" && p.Parent != nil {
+		return p.Parent.Version
+	}
+	return p.Version
+}
+
+// effectivePom is a POM with inheritance, interpolation and BOM imports applied
+type effectivePom struct {
+	GroupId      string
+	ArtifactId   string
+	Version      string
+	Name         string
+	Properties   map[string]string
+	Managed      map[string]pomDependency
+	Dependencies []pomDependency
+	Modules      []string
+}
+
+// Builds the effective model for a POM file. The chain of parents is loaded
+// first so that child properties and managed dependencies override the ones
+// they inherit. Imports holds the BOMs that are being imported further up, so
+// a BOM that imports itself again is caught. A BOM can share parents with the
+// POM that imports it, so those are tracked separately for each chain.
+func (m *Maven) buildEffectivePom(p *pom, imports map[string]bool) (*effectivePom, error) {
+	var chain []*pom
+	visited := map[string]bool{}
+	for curr := p; curr != nil; {
+		key := fmt.Sprintf("%s:%s:%s", curr.groupId(), curr.ArtifactId, curr.version())
+		if visited[key] {
+			return nil, fmt.Errorf("cycle detected while loading %s", key)
+		}
+		visited[key] = true
+		chain = append(chain, curr)
+
+		if curr.Parent == nil {
+			break
+		}
+		parent, err := m.readParentPom(curr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load parent of %s: %s", key, err)
+		}
+		curr = parent
+	}
+
+	eff := &effectivePom{
+		GroupId:    p.groupId(),
+		ArtifactId: p.ArtifactId,
+		Version:    p.version(),
+		Name:       p.Name,
+		Properties: map[string]string{},
+		Managed:    map[string]pomDependency{},
+		Modules:    p.Modules,
+	}
+
+	// Apply the chain from the top-most parent down
+	var managed []pomDependency
+	for i := len(chain) - 1; i >= 0; i-- {
+		curr := chain[i]
+		for k, v := range curr.Properties {
+			eff.Properties[k] = v
+		}
+		if curr.Parent != nil {
+			eff.Properties["project.parent.groupId"] = curr.Parent.GroupId
+			eff.Properties["project.parent.artifactId"] = curr.Parent.ArtifactId
+			eff.Properties["project.parent.version"] = curr.Parent.Version
+		}
+		managed = append(managed, curr.DependencyManagement...)
+		eff.Dependencies = mergeDependencies(eff.Dependencies, curr.Dependencies)
+	}
+
+	// Properties given with -D override the ones in the POM, as they do in Maven
+	for _, property := range m.Properties {
+		split := strings.SplitN(property, "=", 2)
+		if len(split) == 2 {
+			eff.Properties[split[0]] = split[1]
+		} else {
+			eff.Properties[split[0]] = "true"
+		}
+	}
+	// CI-friendly versions such as ${revision} are set through properties
+	eff.Version = eff.interpolate(eff.Version)
+	for _, prefix := range []string{"project.", "pom."} {
+		eff.Properties[prefix+"groupId"] = eff.GroupId
+		eff.Properties[prefix+"artifactId"] = eff.ArtifactId
+		eff.Properties[prefix+"version"] = eff.Version
+	}
+	eff.Properties["parent.version"] = eff.Properties["project.parent.version"]
+	eff.Properties["project.basedir"] = filepath.Dir(p.file)
+	eff.Properties["basedir"] = filepath.Dir(p.file)
+
+	// Managed dependencies from children come later in the list, so they win
+	for _, dep := range managed {
+		dep = eff.interpolateDependency(dep)
+		if dep.Scope == "import" && dep.Type == "pom" {
+			continue
+		}
+		eff.Managed[dep.managementKey()] = dep
+	}
+
+	// Imported BOMs only contribute entries that aren't already managed. The
+	// first import to define an entry wins.
+	for _, dep := range managed {
+		dep = eff.interpolateDependency(dep)
+		if dep.Scope != "import" || dep.Type != "pom" {
+			continue
+		}
+		bomKey := fmt.Sprintf("%s:%s:%s", dep.GroupId, dep.ArtifactId, dep.Version)
+		if imports[bomKey] {
+			log.Warnf("Unable to load imported BOM %s: cycle detected", bomKey)
+			continue
+		}
+		bom, err := m.readRepoPom(dep.GroupId, dep.ArtifactId, dep.Version)
+		if err != nil {
+			log.Warnf("Unable to load imported BOM %s:%s:%s: %s", dep.GroupId, dep.ArtifactId, dep.Version, err)
+			continue
+		}
+		imports[bomKey] = true
+		effBom, err := m.buildEffectivePom(bom, imports)
+		delete(imports, bomKey)
+		if err != nil {
+			log.Warnf("Unable to load imported BOM %s:%s:%s: %s", dep.GroupId, dep.ArtifactId, dep.Version, err)
+			continue
+		}
+		for key, bomDep := range effBom.Managed {
+			if _, ok := eff.Managed[key]; !ok {
+				eff.Managed[key] = bomDep
+			}
+		}
+	}
+
+	for i, dep := range eff.Dependencies {
+		eff.Dependencies[i] = eff.applyManagement(eff.interpolateDependency(dep))
+	}
+	return eff, nil
+}
+
+// Adds a child POM's dependencies to the ones it inherited. A dependency that
+// is declared again in the child replaces the inherited one.
+func mergeDependencies(inherited []pomDependency, declared []pomDependency) []pomDependency {
+	result := append([]pomDependency{}, inherited...)
+	for _, dep := range declared {
+		replaced := false
+		for i := range result {
+			if result[i].managementKey() == dep.managementKey() {
+				result[i] = dep
+				replaced = true
+			}
+		}
+		if !replaced {
+			result = append(result, dep)
+		}
+	}
+	return result
+}
+
+// Replaces ${...} references with property values. Properties can refer to
+// other properties, so this is repeated until nothing changes.
+func (e *effectivePom) interpolate(value string) string {
+	for i := 0; i < 10 && strings.Contains(value, "${"); i++ {
+		replaced := regexProperty.ReplaceAllStringFunc(value, func(ref string) string {
+			name := ref[2 : len(ref)-1]
+			if v, ok := e.Properties[name]; ok {
+				return v
+			}
+			if strings.HasPrefix(name, "env.") {
+				if v, ok := os.LookupEnv(strings.TrimPrefix(name, "env.")); ok {
+					return v
+				}
+			}
+			return ref
+		})
+		if replaced == value {
+			break
+		}
+		value = replaced
+	}
+	return value
+}
+
+func (e *effectivePom) interpolateDependency(dep pomDependency) pomDependency {
+	dep.GroupId = e.interpolate(dep.GroupId)
+	dep.ArtifactId = e.interpolate(dep.ArtifactId)
+	dep.Version = e.interpolate(dep.Version)
+	dep.Type = e.interpolate(dep.Type)
+	dep.Classifier = e.interpolate(dep.Classifier)
+	dep.Scope = e.interpolate(dep.Scope)
+	dep.Optional = e.interpolate(dep.Optional)
+	if dep.Type == "" {
+		dep.Type = "jar"
+	}
+	if dep.Type == "test-jar" && dep.Classifier == "" {
+		dep.Classifier = "tests"
+	}
+	return dep
+}
+
+// Fills in the version, scope and exclusions of a dependency from the managed
+// dependencies, if it doesn't declare them itself
+func (e *effectivePom) applyManagement(dep pomDependency) pomDependency {
+	managed, ok := e.Managed[dep.managementKey()]
+	if !ok {
+		return dep
+	}
+	if dep.Version == "" {
+		dep.Version = managed.Version
+	}
+	if dep.Scope == "" {
+		dep.Scope = managed.Scope
+	}
+	if len(dep.Exclusions) == 0 {
+		dep.Exclusions = managed.Exclusions
+	}
+	return dep
+}
@@ -0,0 +1,238 @@
+package maven
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sif/models"
+	"strings"
+	"time"
+)
+
+type settingsMirror struct {
+	Id       string `xml:"id"`
+	Url      string `xml:"url"`
+	MirrorOf string `xml:"mirrorOf"`
+}
+
+type settingsServer struct {
+	Id       string `xml:"id"`
+	Username string `xml:"username"`
+	Password string `xml:"password"`
+}
+
+type settings struct {
+	Mirrors []settingsMirror `xml:"mirrors>mirror"`
+	Servers []settingsServer `xml:"servers>server"`
+}
+
+// Checks a mirrorOf expression against a repository ID. Expressions are a comma
+// separated list of IDs, where * matches anything, external:* matches anything
+// that isn't on this machine and a leading ! excludes an ID.
+func mirrorMatches(mirrorOf string, repoId string, repoUrl string) bool {
+	matched := false
+	for _, pattern := range strings.Split(mirrorOf, ",") {
+		pattern = strings.TrimSpace(pattern)
+		switch {
+		case pattern == "!"+repoId:
+			return false
+		case pattern == "*" || pattern == repoId:
+			matched = true
+		case pattern == "external:*":
+			u, err := url.Parse(repoUrl)
+			if err == nil && u.Scheme != "file" && u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1" {
+				matched = true
+			}
+		}
+	}
+	return matched
+}
+
+// remoteRepository looks up the size of artifacts that aren't in the local
+// repository. Sizes that were found are cached on disk so that repeated runs
+// don't hit the network.
+type remoteRepository struct {
+	id        string
+	url       string
+	username  string
+	password  string
+	client    *http.Client
+	cacheFile string
+	cache     map[string]int64
+	dirty     bool
+	// Artifacts the repository doesn't have. They may be published later, so
+	// they are only remembered for this run.
+	misses map[string]bool
+}
+
+// Sets up the remote repository, switching to a mirror and picking up
+// credentials from settings.xml if it defines them
+func (m *Maven) newRemoteRepository() *remoteRepository {
+	remote := &remoteRepository{
+		id:        m.RemoteRepoId,
+		url:       strings.TrimRight(m.RemoteRepo, "/"),
+		client:    &http.Client{Timeout: 30 * time.Second},
+		cacheFile: m.RemoteCacheFile,
+		cache:     map[string]int64{},
+		misses:    map[string]bool{},
+	}
+
+	if data, err := ioutil.ReadFile(m.SettingsFile); err == nil {
+		var s settings
+		if err := xml.Unmarshal(data, &s); err != nil {
+			log.Warnf("Unable to parse Maven settings file %s: %s", m.SettingsFile, err)
+		}
+		for _, mirror := range s.Mirrors {
+			if mirrorMatches(mirror.MirrorOf, remote.id, remote.url) {
+				log.Debugf("Using mirror %s (%s) for repository %s", mirror.Id, mirror.Url, remote.id)
+				remote.id = mirror.Id
+				remote.url = strings.TrimRight(mirror.Url, "/")
+				break
+			}
+		}
+		for _, server := range s.Servers {
+			if server.Id == remote.id {
+				if strings.HasPrefix(server.Password, "{") {
+					log.Warnf("Encrypted password for server %s is not supported, connecting without credentials", server.Id)
+				} else {
+					remote.username = server.Username
+					remote.password = server.Password
+				}
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		log.Warnf("Unable to read Maven settings file %s: %s", m.SettingsFile, err)
+	}
+
+	if remote.cacheFile != "" {
+		if data, err := ioutil.ReadFile(remote.cacheFile); err == nil {
+			if err := json.Unmarshal(data, &remote.cache); err != nil {
+				log.Warnf("Ignoring corrupt remote size cache %s: %s", remote.cacheFile, err)
+				remote.cache = map[string]int64{}
+			}
+			// Older versions saved misses as -1
+			for artifactUrl, size := range remote.cache {
+				if size < 0 {
+					delete(remote.cache, artifactUrl)
+				}
+			}
+		}
+	}
+	return remote
+}
+
+// Returns the URL of the artifact file in the remote repository
+func (r *remoteRepository) artifactUrl(dep *models.Dependency) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s",
+		r.url,
+		strings.ReplaceAll(dep.GroupId, ".", "/"),
+		dep.ArtifactId,
+		dep.Version,
+		dep.FileName())
+}
+
+// Returns the size of the artifact in the remote repository, or -1 if the
+// repository doesn't have it
+func (r *remoteRepository) fileSize(artifactUrl string) int64 {
+	if size, ok := r.cache[artifactUrl]; ok {
+		return size
+	}
+	if r.misses[artifactUrl] {
+		return -1
+	}
+
+	size, err := r.fetchSize(artifactUrl)
+	if err != nil {
+		// Don't cache errors, only definitive answers
+		log.Warnf("Unable to determine size of %s: %s", artifactUrl, err)
+		return -1
+	}
+	if size < 0 {
+		log.Debugf("%s is not in the remote repository", artifactUrl)
+		r.misses[artifactUrl] = true
+		return size
+	}
+	log.Debugf("Remote size of %s is %d", artifactUrl, size)
+	r.cache[artifactUrl] = size
+	r.dirty = true
+	return size
+}
+
+func (r *remoteRepository) fetchSize(artifactUrl string) (int64, error) {
+	if strings.HasPrefix(artifactUrl, "file://") {
+		u, err := url.Parse(artifactUrl)
+		if err != nil {
+			return -1, err
+		}
+		stats, err := os.Stat(filepath.FromSlash(u.Path))
+		if os.IsNotExist(err) {
+			return -1, nil
+		} else if err != nil {
+			return -1, err
+		}
+		return stats.Size(), nil
+	}
+
+	// Most repositories answer a HEAD request with the content length, but
+	// fall back to downloading the file for ones that don't
+	resp, err := r.request(http.MethodHead, artifactUrl)
+	if err != nil {
+		return -1, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return -1, nil
+	}
+	if resp.StatusCode == http.StatusOK && resp.ContentLength >= 0 {
+		return resp.ContentLength, nil
+	}
+
+	resp, err = r.request(http.MethodGet, artifactUrl)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return -1, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return -1, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return io.Copy(ioutil.Discard, resp.Body)
+}
+
+func (r *remoteRepository) request(method string, artifactUrl string) (*http.Response, error) {
+	req, err := http.NewRequest(method, artifactUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	return r.client.Do(req)
+}
+
+// Writes any newly looked up sizes to the cache file
+func (r *remoteRepository) saveCache() {
+	if !r.dirty || r.cacheFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(r.cache, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(r.cacheFile), 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(r.cacheFile, data, 0644)
+	}
+	if err != nil {
+		log.Warnf("Unable to save remote size cache %s: %s", r.cacheFile, err)
+	}
+	r.dirty = false
+}
@@ -0,0 +1,229 @@
+package maven
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sif/models"
+	"strconv"
+	"strings"
+)
+
+// Output types of dependency:tree that can be parsed without scraping the log,
+// in order of preference
+var structuredOutputTypes = []string{"json", "tgf"}
+
+// The first version of the dependency plugin that can write each output type
+var outputTypeVersions = map[string]string{"json": "3.7.0", "tgf": "2.2"}
+
+// Matches the goal Maven reports as failed, with the artifact ID and version
+// of its plugin. Failures outside of a plugin, such as unresolvable
+// dependencies, name no goal.
+var failedGoalRegex = regexp.MustCompile(`Failed to execute goal (?:[^\s:]+:([^\s:]+):([^\s:]+):)?`)
+
+// treeNode is an artifact in a dependency tree written by dependency:tree
+type treeNode struct {
+	GroupId    string      `json:"groupId"`
+	ArtifactId string      `json:"artifactId"`
+	Version    string      `json:"version"`
+	Type       string      `json:"type"`
+	Classifier string      `json:"classifier"`
+	Scope      string      `json:"scope"`
+	Children   []*treeNode `json:"children"`
+}
+
+// Runs dependency:tree with each structured output type until one works,
+// writing the tree to a temporary file. Returns a root for each module in the
+// build, or false if the plugin is too old to write any of them or a verbose
+// tree was asked for, in which case the log output has to be parsed instead.
+// If the build failed for another reason, the failure is kept so that the
+// build isn't run again.
+func (m *Maven) runStructuredTree() ([]*treeNode, bool) {
+	// Structured output doesn't include what a verbose tree adds
+	if m.Verbose {
+		return nil, false
+	}
+	recorder := m.recorder()
+	for _, outputType := range structuredOutputTypes {
+		step := fmt.Sprintf("dependency-tree-%s", outputType)
+		if !recorder.Has(step) {
+			continue
+		}
+
+		f, err := ioutil.TempFile("", fmt.Sprintf("sif-tree-*.%s", outputType))
+		if err != nil {
+			log.Fatalf("Failed to create file for dependency tree: %s", err)
+		}
+		f.Close()
+
+		// Every module writes to the same file, so it has to be appended to
+		args := append(m.treeArgs(),
+			fmt.Sprintf("-DoutputType=%s", outputType),
+			fmt.Sprintf("-DoutputFile=%s", f.Name()),
+			"-DappendOutput=true")
+		result := recorder.RunWithOutputFile(step, f.Name(), m.MavenCommand, args...)
+		os.Remove(f.Name())
+		if result.Err != nil {
+			log.Debugf("Unable to write dependency tree as %s: %s", outputType, result.Err)
+			if !outputTypeUnsupported(outputType, result.Stdout) {
+				m.treeFailure = &result
+				return nil, false
+			}
+			continue
+		}
+		m.checkMultiModulePom(result.Stdout)
+
+		var roots []*treeNode
+		if outputType == "json" {
+			roots, err = parseJSONTree(result.Output)
+		} else {
+			roots, err = parseTGFTree(result.Output)
+		}
+		if err != nil || len(roots) == 0 {
+			log.Warnf("Unable to parse dependency tree written as %s: %v", outputType, err)
+			continue
+		}
+		return roots, true
+	}
+	return nil, false
+}
+
+// Returns whether a failed run could have failed because the dependency plugin
+// can't write the output type. Maven names the goal that failed, so it is only
+// the output type if the goal is the dependency plugin's and its version is
+// older than the one that added it. Output that doesn't say is given the
+// benefit of the doubt.
+func outputTypeUnsupported(outputType string, output string) bool {
+	failed := failedGoalRegex.FindStringSubmatch(output)
+	if failed == nil {
+		return true
+	}
+	return failed[1] == "maven-dependency-plugin" && compareVersions(failed[2], outputTypeVersions[outputType]) < 0
+}
+
+// Compares the numeric parts of two versions, returning a negative number if a
+// is older, zero if they are the same, or a positive number if it is newer
+func compareVersions(a string, b string) int {
+	partsA := strings.Split(a, ".")
+	partsB := strings.Split(b, ".")
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		var numA, numB int
+		if i < len(partsA) {
+			numA, _ = strconv.Atoi(strings.SplitN(partsA[i], "-", 2)[0])
+		}
+		if i < len(partsB) {
+			numB, _ = strconv.Atoi(strings.SplitN(partsB[i], "-", 2)[0])
+		}
+		if numA != numB {
+			return numA - numB
+		}
+	}
+	return 0
+}
+
+// Parses the JSON written by dependency:tree. Each module appends its own tree
+// to the file, so there may be more than one document.
+func parseJSONTree(output string) ([]*treeNode, error) {
+	var roots []*treeNode
+	decoder := json.NewDecoder(strings.NewReader(output))
+	for {
+		var root treeNode
+		err := decoder.Decode(&root)
+		if err == io.EOF {
+			return roots, nil
+		} else if err != nil {
+			return nil, err
+		}
+		roots = append(roots, &root)
+	}
+}
+
+// Parses the Trivial Graph Format written by dependency:tree. Each module's
+// graph lists the nodes, then a # line, then the edges between them:
+//
+//	<id> <groupId>:<artifactId>:<type>[:<classifier>]:<version>[:<scope>]
+//	#
+//	<parent id> <child id> <scope>
+//
+// The first node of a graph is the module itself.
+func parseTGFTree(output string) ([]*treeNode, error) {
+	var roots []*treeNode
+	var nodes map[string]*treeNode
+	inEdges := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 0:
+			continue
+		case fields[0] == "#":
+			inEdges = true
+		case len(fields) == 2 && strings.Contains(fields[1], ":"):
+			// A node after the edges starts the next module's graph
+			if inEdges || nodes == nil {
+				inEdges = false
+				nodes = map[string]*treeNode{}
+			}
+			node, err := parseTGFNode(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			if len(nodes) == 0 {
+				roots = append(roots, node)
+			}
+			nodes[fields[0]] = node
+		case inEdges && len(fields) >= 2:
+			parent, child := nodes[fields[0]], nodes[fields[1]]
+			if parent == nil || child == nil {
+				return nil, fmt.Errorf("edge refers to unknown node: %s", scanner.Text())
+			}
+			parent.Children = append(parent.Children, child)
+		default:
+			return nil, fmt.Errorf("unexpected line: %s", scanner.Text())
+		}
+	}
+	return roots, scanner.Err()
+}
+
+func parseTGFNode(label string) (*treeNode, error) {
+	split := strings.Split(label, ":")
+	switch len(split) {
+	case 4:
+		return &treeNode{GroupId: split[0], ArtifactId: split[1], Type: split[2], Version: split[3]}, nil
+	case 5:
+		return &treeNode{GroupId: split[0], ArtifactId: split[1], Type: split[2], Version: split[3], Scope: split[4]}, nil
+	case 6:
+		return &treeNode{GroupId: split[0], ArtifactId: split[1], Type: split[2], Classifier: split[3], Version: split[4], Scope: split[5]}, nil
+	}
+	return nil, fmt.Errorf("unexpected artifact: %s", label)
+}
+
+// Builds a project from the tree of a module, sizing each dependency
+func (m *Maven) toProject(root *treeNode) models.Project {
+	return models.Project{
+		Name:         root.ArtifactId,
+		Version:      root.Version,
+		Dependencies: m.toTreeDependencies(root.Children),
+	}
+}
+
+func (m *Maven) toTreeDependencies(nodes []*treeNode) []models.Dependency {
+	var deps []models.Dependency
+	for _, node := range nodes {
+		result := m.determineFileSize(&models.Dependency{
+			GroupId:    node.GroupId,
+			ArtifactId: node.ArtifactId,
+			Version:    node.Version,
+			Classifier: node.Classifier,
+			Extension:  TypeExtension(node.Type),
+		})
+		result.Children = m.toTreeDependencies(node.Children)
+		deps = append(deps, result)
+	}
+	return deps
+}
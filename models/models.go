@@ -1,19 +1,96 @@
 package models
 
+import "fmt"
+
 type RootCtx struct {
 	LogLevel                      string
 	LargeDependencyThreshold      string
 	LargeDependencyThresholdBytes uint64
 	LargeDependenciesOnly         bool
+	Reverse                       bool
+	Collapse                      bool
+	TUI                           bool
+	Top                           int
+	TopBy                         string
+	Sort                          string
+	MaxDepth                      int
+	Include                       []string
+	Exclude                       []string
+	MinSize                       string
+	MinSizeBytes                  uint64
+	OutputFormat                  string
+	Strict                        bool
+	SaveRawDir                    string
+	ReplayDir                     string
 }
 
+// ResolutionStatus describes whether the file for a dependency could be found
+type ResolutionStatus string
+
+const (
+	// The artifact file was found and its size is known
+	StatusFound ResolutionStatus = "found"
+	// Neither the artifact file nor its POM could be found
+	StatusMissing ResolutionStatus = "missing"
+	// The POM was found, but the artifact file itself was not
+	StatusPomOnly ResolutionStatus = "pom-only"
+	// The artifact was moved to new coordinates and sized from there
+	StatusRelocated ResolutionStatus = "relocated"
+	// The dependency is another project of the same build, which has no artifact file
+	StatusProject ResolutionStatus = "project"
+	// The build tool left this version off the classpath in favor of another one
+	StatusOmitted ResolutionStatus = "omitted"
+)
+
 type Dependency struct {
 	GroupId    string
 	ArtifactId string
 	Version    string
+	Classifier string
 	Extension  string
 	Size       uint64
 	Children   []Dependency
+
+	Status        ResolutionStatus
+	RelocatedTo   string
+	SearchedPaths []string
+	// The local file the dependency was sized from, if there is one
+	File string
+
+	// Why the build tool picked this version, if it reports it
+	SelectionReason string
+
+	// Version mediation details reported by a verbose Maven tree
+	OmittedForConflictWith string
+	VersionManagedFrom     string
+}
+
+// Unresolved returns true if the size of the dependency could not be determined
+func (d *Dependency) Unresolved() bool {
+	return d.Status == StatusMissing || d.Status == StatusPomOnly
+}
+
+// Coordinates returns the dependency as it is shown in the tree. Projects of
+// the same build are shown by their path, the way Gradle prints them.
+func (d *Dependency) Coordinates() string {
+	if d.Status == StatusProject {
+		return fmt.Sprintf("project %s", d.ArtifactId)
+	}
+	return fmt.Sprintf("%s:%s:%s", d.GroupId, d.ArtifactId, d.Version)
+}
+
+// Omitted returns true if the dependency is shown in the tree but isn't on the
+// classpath, so it doesn't count towards any totals
+func (d *Dependency) Omitted() bool {
+	return d.Status == StatusOmitted
+}
+
+// FileName returns the name the artifact file is stored under in a repository
+func (d *Dependency) FileName() string {
+	if d.Classifier != "" {
+		return fmt.Sprintf("%s-%s-%s.%s", d.ArtifactId, d.Version, d.Classifier, d.Extension)
+	}
+	return fmt.Sprintf("%s-%s.%s", d.ArtifactId, d.Version, d.Extension)
 }
 
 type Project struct {
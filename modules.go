@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/fatih/color"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sif/models"
+	"sort"
+	"strings"
+)
+
+// moduleUsage is a dependency along with the modules whose classpath it is on
+type moduleUsage struct {
+	Dependency *models.Dependency
+	Modules    []string
+}
+
+// moduleAggregate summarizes the classpaths of all modules in a build
+type moduleAggregate struct {
+	UnionSize  uint64
+	UnionCount int
+	Shared     []moduleUsage
+}
+
+type jsonModuleUsage struct {
+	GroupId    string   `json:"groupId"`
+	ArtifactId string   `json:"artifactId"`
+	Version    string   `json:"version"`
+	Size       uint64   `json:"size"`
+	Modules    []string `json:"modules"`
+}
+
+type jsonAggregate struct {
+	UnionSize  uint64            `json:"unionSize"`
+	UnionCount int               `json:"unionCount"`
+	Shared     []jsonModuleUsage `json:"shared"`
+}
+
+type jsonModules struct {
+	Modules   []jsonProject `json:"modules"`
+	Aggregate jsonAggregate `json:"aggregate"`
+}
+
+// Collects every unique dependency across all modules. Dependencies are keyed
+// by their full coordinates, so two modules using different versions of the
+// same library both count towards the union.
+func aggregateModules(projects []models.Project) moduleAggregate {
+	var order []string
+	usages := map[string]*moduleUsage{}
+	for _, project := range projects {
+		var walk func(deps []models.Dependency)
+		walk = func(deps []models.Dependency) {
+			for i := range deps {
+				dep := &deps[i]
+				if dep.Omitted() {
+					continue
+				}
+				key := fmt.Sprintf("%s:%s:%s:%s", dep.GroupId, dep.ArtifactId, dep.Version, dep.Classifier)
+				usage, ok := usages[key]
+				if !ok {
+					usage = &moduleUsage{Dependency: dep}
+					usages[key] = usage
+					order = append(order, key)
+				}
+				if len(usage.Modules) == 0 || usage.Modules[len(usage.Modules)-1] != project.Name {
+					usage.Modules = append(usage.Modules, project.Name)
+				}
+				walk(dep.Children)
+			}
+		}
+		walk(project.Dependencies)
+	}
+
+	var result moduleAggregate
+	for _, key := range order {
+		usage := usages[key]
+		result.UnionSize += usage.Dependency.Size
+		result.UnionCount++
+		if len(usage.Modules) > 1 {
+			result.Shared = append(result.Shared, *usage)
+		}
+	}
+
+	// Show the shared dependencies that cost the most first
+	sort.SliceStable(result.Shared, func(i, j int) bool {
+		return result.Shared[i].Dependency.Size > result.Shared[j].Dependency.Size
+	})
+	return result
+}
+
+// Renders each module of a multi-module build, followed by the aggregate of
+// all of them, in the format selected with --output
+func printModules(projects []models.Project) {
+	if rootCtx.TUI {
+		log.Fatalf("--tui can only explore one module or project at a time")
+	}
+	switch rootCtx.OutputFormat {
+	case OutputTree:
+		printModulesTree(projects)
+	case OutputJSON:
+		if rootCtx.Top > 0 {
+			printModulesTopJSON(projects, os.Stdout)
+		} else {
+			printModulesJSON(projects, os.Stdout)
+		}
+	default:
+		log.Fatalf("Output format %s is not supported for multiple modules", rootCtx.OutputFormat)
+	}
+
+	unresolved := 0
+	for _, project := range projects {
+		unresolved += len(unresolvedDependencies(project))
+	}
+	if rootCtx.Strict && unresolved > 0 {
+		log.Fatalf("%d artifacts could not be found", unresolved)
+	}
+}
+
+func printModulesTree(projects []models.Project) {
+	for _, project := range projects {
+		if rootCtx.Top > 0 {
+			printTopTable(project)
+		} else {
+			printTree(project)
+		}
+		log.Info("")
+	}
+
+	aggregate := aggregateModules(projects)
+	log.Infof("Aggregate of %d modules", len(projects))
+	log.Infof("Union classpath: %s in %d unique dependencies", humanize.Bytes(aggregate.UnionSize), aggregate.UnionCount)
+	if len(aggregate.Shared) == 0 {
+		log.Info("No dependencies are shared between modules")
+		return
+	}
+	log.Info("Dependencies shared by multiple modules:")
+	for i, usage := range aggregate.Shared {
+		dep := usage.Dependency
+		prefix := "├── "
+		if i == len(aggregate.Shared)-1 {
+			prefix = "└── "
+		}
+		fileColor := color.New(color.Reset)
+		if dep.Size > rootCtx.LargeDependencyThresholdBytes {
+			fileColor = color.New(color.BgRed)
+		}
+		log.Infof("%s%s%s Size[%s] Modules[%d: %s]",
+			prefix,
+			dep.Coordinates(),
+			statusMarker(dep),
+			fileColor.Sprintf("File: %s", humanize.Bytes(dep.Size)),
+			len(usage.Modules),
+			strings.Join(usage.Modules, ", "))
+	}
+}
+
+func printModulesJSON(projects []models.Project, w io.Writer) {
+	result := jsonModules{Modules: []jsonProject{}}
+	for _, project := range projects {
+		result.Modules = append(result.Modules, toJSONProject(project))
+	}
+
+	aggregate := aggregateModules(projects)
+	result.Aggregate = jsonAggregate{
+		UnionSize:  aggregate.UnionSize,
+		UnionCount: aggregate.UnionCount,
+		Shared:     []jsonModuleUsage{},
+	}
+	for _, usage := range aggregate.Shared {
+		result.Aggregate.Shared = append(result.Aggregate.Shared, jsonModuleUsage{
+			GroupId:    usage.Dependency.GroupId,
+			ArtifactId: usage.Dependency.ArtifactId,
+			Version:    usage.Dependency.Version,
+			Size:       usage.Dependency.Size,
+			Modules:    usage.Modules,
+		})
+	}
+	writeJSON(w, result)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sif/models"
+	"strings"
+)
+
+// The registry npm packages are looked up from, since SBOMs don't say where a
+// package was downloaded from
+const npmRegistry = "https://registry.npmjs.org"
+
+// npmIndexEntry is an entry in the index of the npm cache
+type npmIndexEntry struct {
+	Key       string `json:"key"`
+	Integrity string `json:"integrity"`
+	Size      uint64 `json:"size"`
+}
+
+// Returns the URL npm downloads the tarball of a package from. Scoped packages
+// keep their scope in the path, but not in the file name.
+func npmTarballURL(scope, name, version string) string {
+	path := name
+	if scope != "" {
+		path = scope + "/" + name
+	}
+	return fmt.Sprintf("%s/%s/-/%s-%s.tgz", npmRegistry, path, name, version)
+}
+
+// Returns where the npm cache keeps content with the given integrity, which is
+// an algorithm and a base64 digest such as sha512-...
+func npmContentPath(cache, integrity string) string {
+	hashes := strings.Fields(integrity)
+	if len(hashes) == 0 {
+		return ""
+	}
+	fields := strings.SplitN(hashes[0], "-", 2)
+	if len(fields) != 2 {
+		return ""
+	}
+	digest, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil || len(digest) < 3 {
+		return ""
+	}
+	hash := hex.EncodeToString(digest)
+	return filepath.Join(cache, "content-v2", fields[0], hash[:2], hash[2:4], hash[4:])
+}
+
+// Finds the tarball of an npm package in the npm cache and records its size.
+// The cache is content-addressed. An index bucket, named after the SHA-256
+// hash of the tarball's URL, points to the content by its integrity hash:
+//
+//	<cache>/index-v5/<hash[0:2]>/<hash[2:4]>/<hash[4:]>
+//	<cache>/content-v2/<algorithm>/<digest[0:2]>/<digest[2:4]>/<digest[4:]>
+func determineNpmSize(cache string, dep *models.Dependency, scope, name string) {
+	key := "make-fetch-happen:request-cache:" + npmTarballURL(scope, name, dep.Version)
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+	bucket := filepath.Join(cache, "index-v5", hash[:2], hash[2:4], hash[4:])
+	dep.Size = 0
+	dep.Status = models.StatusMissing
+	dep.SearchedPaths = []string{bucket}
+
+	data, err := ioutil.ReadFile(bucket)
+	if err != nil {
+		return
+	}
+	// Each line is the SHA-1 hash of an entry, a tab and the entry. Buckets are
+	// only appended to, so the last entry for the key wins, and one without an
+	// integrity hash means it was removed.
+	var found *npmIndexEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		var entry npmIndexEntry
+		if err := json.Unmarshal([]byte(fields[1]), &entry); err != nil || entry.Key != key {
+			continue
+		}
+		if entry.Integrity == "" {
+			found = nil
+		} else {
+			found = &entry
+		}
+	}
+	if found == nil {
+		return
+	}
+	dep.Size = found.Size
+	dep.Status = models.StatusFound
+	if file := npmContentPath(cache, found.Integrity); file != "" {
+		if _, err := os.Stat(file); err == nil {
+			dep.File = file
+		}
+	}
+}
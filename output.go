@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sif/models"
+)
+
+const (
+	OutputTree    = "tree"
+	OutputJSON    = "json"
+	OutputHTML    = "html"
+	OutputDot     = "dot"
+	OutputMermaid = "mermaid"
+	// CycloneDX SBOMs, as JSON or XML
+	OutputCycloneDX    = "cyclonedx"
+	OutputCycloneDXXML = "cyclonedx-xml"
+	// An SPDX 2.3 SBOM as JSON
+	OutputSPDX = "spdx-json"
+)
+
+type jsonDependency struct {
+	GroupId    string           `json:"groupId"`
+	ArtifactId string           `json:"artifactId"`
+	Version    string           `json:"version"`
+	Classifier string           `json:"classifier,omitempty"`
+	Extension  string           `json:"extension,omitempty"`
+	Size       uint64           `json:"size"`
+	TotalSize  uint64           `json:"totalSize"`
+	Large      bool             `json:"large"`
+	Status     string           `json:"status"`
+	Relocated  string           `json:"relocatedTo,omitempty"`
+	Searched   []string         `json:"searchedPaths,omitempty"`
+	Reason     string           `json:"selectionReason,omitempty"`
+	Conflict   string           `json:"omittedForConflictWith,omitempty"`
+	Managed    string           `json:"versionManagedFrom,omitempty"`
+	Children   []jsonDependency `json:"children"`
+}
+
+type jsonProject struct {
+	Name            string           `json:"name"`
+	Version         string           `json:"version"`
+	TotalSize       uint64           `json:"totalSize"`
+	DependencyCount int              `json:"dependencyCount"`
+	UnresolvedCount int              `json:"unresolvedCount"`
+	Dependencies    []jsonDependency `json:"dependencies"`
+}
+
+// reporter prints the results of an analysis. The analysis commands are shared
+// between the plain report and queries such as why, which print the same
+// results differently.
+type reporter struct {
+	project func(models.Project)
+	modules func([]models.Project)
+	scopes  func([]scopedProject)
+}
+
+var defaultReporter = reporter{
+	project: printResult,
+	modules: printModules,
+	scopes:  printScopes,
+}
+
+// Renders the project in the format selected with --output
+func printResult(project models.Project) {
+	if rootCtx.TUI {
+		// The explorer only returns once the user quits, so --strict is checked
+		// before it starts
+		checkStrict(project)
+		runExplorer(project)
+		return
+	}
+
+	switch rootCtx.OutputFormat {
+	case OutputTree:
+		if rootCtx.Top > 0 {
+			printTopTable(project)
+		} else {
+			printTree(project)
+		}
+	case OutputJSON:
+		if rootCtx.Top > 0 {
+			printTopJSON(project, os.Stdout)
+		} else {
+			printJSON(project, os.Stdout)
+		}
+	case OutputHTML:
+		printHTML(project, os.Stdout)
+	case OutputDot:
+		printDot(project, os.Stdout)
+	case OutputMermaid:
+		printMermaid(project, os.Stdout)
+	case OutputCycloneDX:
+		printCycloneDX(project, os.Stdout)
+	case OutputCycloneDXXML:
+		printCycloneDXXML(project, os.Stdout)
+	case OutputSPDX:
+		printSPDX(project, os.Stdout)
+	default:
+		log.Fatalf("Unknown output format: %s", rootCtx.OutputFormat)
+	}
+	checkStrict(project)
+}
+
+// Fails if --strict is given and any of the project's artifacts could not be found
+func checkStrict(project models.Project) {
+	if unresolved := unresolvedDependencies(project); rootCtx.Strict && len(unresolved) > 0 {
+		log.Fatalf("%d artifacts could not be found", len(unresolved))
+	}
+}
+
+func toJSONDependencies(deps []AnalyzedDependency) []jsonDependency {
+	result := []jsonDependency{}
+	for i := range deps {
+		entry := &deps[i]
+		dep := entry.Dependency
+		if rootCtx.LargeDependenciesOnly && entry.Depth == 0 && entry.TotalSize <= rootCtx.LargeDependencyThresholdBytes {
+			continue
+		}
+		var searched []string
+		if dep.Unresolved() {
+			searched = dep.SearchedPaths
+		}
+		result = append(result, jsonDependency{
+			GroupId:    dep.GroupId,
+			ArtifactId: dep.ArtifactId,
+			Version:    dep.Version,
+			Classifier: dep.Classifier,
+			Extension:  dep.Extension,
+			Size:       dep.Size,
+			TotalSize:  entry.TotalSize,
+			Large:      dep.Size > rootCtx.LargeDependencyThresholdBytes,
+			Status:     string(dep.Status),
+			Relocated:  dep.RelocatedTo,
+			Searched:   searched,
+			Reason:     dep.SelectionReason,
+			Conflict:   dep.OmittedForConflictWith,
+			Managed:    dep.VersionManagedFrom,
+			Children:   toJSONDependencies(*entry.Children),
+		})
+	}
+	return result
+}
+
+func toJSONProject(project models.Project) jsonProject {
+	analyzedDeps := calculateTotalSizes(project)
+	result := jsonProject{
+		Name:    project.Name,
+		Version: project.Version,
+	}
+	for _, dep := range analyzedDeps {
+		result.TotalSize += dep.TotalSize
+	}
+	result.DependencyCount, _ = countDependencies(analyzedDeps)
+	if rootCtx.Reverse {
+		analyzedDeps = reverseDependencies(analyzedDeps)
+	}
+	result.Dependencies = toJSONDependencies(shapeTree(analyzedDeps, nil, false))
+	result.UnresolvedCount = len(unresolvedDependencies(project))
+	return result
+}
+
+func writeJSON(w io.Writer, value interface{}) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.Fatalf("Failed to write JSON output: %s", err)
+	}
+}
+
+func printJSON(project models.Project, w io.Writer) {
+	writeJSON(w, toJSONProject(project))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"sif/gradle"
+	"sif/maven"
+	"sif/models"
+)
+
+const (
+	FormatMavenTree  = "maven-tree"
+	FormatGradleTree = "gradle-tree"
+)
+
+// SavedOutput analyzes build tool output that was captured ahead of time, such
+// as a dependency:tree log from CI, without running the build tool itself.
+type SavedOutput struct {
+	RootCtx       models.RootCtx
+	Format        string
+	Repo          string
+	Configuration string
+	AllModules    bool
+}
+
+// Reads the file, or stdin if it is -
+func readFileOrStdin(file string) ([]byte, error) {
+	if file == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(resolvePath(file))
+}
+
+func (s *SavedOutput) readInput(file string) string {
+	data, err := readFileOrStdin(file)
+	if err != nil {
+		log.Fatalf("Failed to read saved output from %s: %s", file, err)
+	}
+	return string(data)
+}
+
+func (s *SavedOutput) Analyze(file string) models.Project {
+	switch s.Format {
+	case FormatMavenTree:
+		m := maven.Maven{
+			RootCtx:   s.RootCtx,
+			MavenRepo: s.resolveRepo(defaultMavenRepo),
+		}
+		return m.Parse(s.readInput(file))
+	case FormatGradleTree:
+		g := gradle.Gradle{
+			RootCtx:       s.RootCtx,
+			Configuration: s.Configuration,
+			GradleCache:   s.resolveRepo(defaultGradleCache),
+		}
+		return g.Parse(s.readInput(file))
+	case "":
+		log.Fatalf("No format specified. Please select one with the --format option (%s or %s)", FormatMavenTree, FormatGradleTree)
+	default:
+		log.Fatalf("Unknown format: %s", s.Format)
+	}
+	return models.Project{}
+}
+
+// AnalyzeModules builds a project for each module found in the saved output
+func (s *SavedOutput) AnalyzeModules(file string) []models.Project {
+	switch s.Format {
+	case FormatMavenTree:
+		m := maven.Maven{
+			RootCtx:    s.RootCtx,
+			MavenRepo:  s.resolveRepo(defaultMavenRepo),
+			AllModules: true,
+		}
+		return m.ParseModules(s.readInput(file))
+	case FormatGradleTree:
+		g := gradle.Gradle{
+			RootCtx:       s.RootCtx,
+			Configuration: s.Configuration,
+			GradleCache:   s.resolveRepo(defaultGradleCache),
+			AllProjects:   true,
+		}
+		return g.ParseProjects(s.readInput(file))
+	default:
+		log.Fatalf("Analyzing all modules is not supported for format: %s", s.Format)
+	}
+	return nil
+}
+
+func (s *SavedOutput) resolveRepo(defaultRepo string) string {
+	if s.Repo == "" {
+		return resolvePath(defaultRepo)
+	}
+	return resolvePath(s.Repo)
+}
@@ -0,0 +1,244 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	infoFile   = "info.json"
+	stdoutFile = "stdout.txt"
+	stderrFile = "stderr.txt"
+	outputFile = "output.txt"
+)
+
+// System and project properties whose values are credentials, such as
+// -Dhttp.proxyPassword=... or -PmavenToken=...
+var secretPropertyRegex = regexp.MustCompile(`(-[DP][^\s=]*(?i:password|passwd|token|secret|credential)[^\s=]*=)\S*`)
+
+// Environment variables that commonly change how a build tool resolves
+// dependencies. Only these are recorded, and credentials passed as properties
+// in them are redacted, so a bundle doesn't leak secrets.
+var recordedEnvVars = []string{
+	"PATH",
+	"JAVA_HOME",
+	"JAVA_OPTS",
+	"M2_HOME",
+	"MAVEN_HOME",
+	"MAVEN_OPTS",
+	"MAVEN_ARGS",
+	"GRADLE_HOME",
+	"GRADLE_USER_HOME",
+	"GRADLE_OPTS",
+}
+
+// Info describes a single recorded command invocation.
+type Info struct {
+	Command     []string
+	WorkingDir  string
+	Environment map[string]string
+	ExitCode    int
+	Recorded    time.Time
+}
+
+// Result holds the captured output of a command, whether it was run or replayed.
+type Result struct {
+	Stdout string
+	Stderr string
+	Err    error
+
+	// Contents of the file the command wrote its results to, if any
+	Output string
+}
+
+// Recorder runs external build commands. If SaveDir is set, everything the
+// command produced is written to disk so it can be sent to someone else. If
+// ReplayDir is set, nothing is run and the previously saved output is returned.
+type Recorder struct {
+	SaveDir   string
+	ReplayDir string
+}
+
+// Has returns false if the recorder is replaying and the step wasn't recorded.
+// Analyzers use it to skip optional steps when replaying older recordings.
+func (r *Recorder) Has(step string) bool {
+	if r.ReplayDir == "" {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(r.ReplayDir, step, infoFile))
+	return err == nil
+}
+
+// Run executes the named command, or replays it if the recorder is in replay
+// mode. Each step is stored in its own subdirectory so that analyzers that run
+// more than one command can be replayed in full.
+func (r *Recorder) Run(step string, name string, args ...string) Result {
+	if r.ReplayDir != "" {
+		return r.replay(step)
+	}
+	result := r.execute(name, args...)
+	if r.SaveDir != "" {
+		r.save(step, append([]string{name}, args...), result)
+	}
+	return result
+}
+
+// RunWithOutputFile is like Run, for commands that write their results to a
+// file rather than stdout. The contents of the file are returned in Output and
+// are saved and replayed along with the rest of the step.
+func (r *Recorder) RunWithOutputFile(step string, file string, name string, args ...string) Result {
+	if r.ReplayDir != "" {
+		return r.replay(step)
+	}
+	result := r.execute(name, args...)
+	if data, err := ioutil.ReadFile(file); err == nil {
+		result.Output = string(data)
+	}
+	if r.SaveDir != "" {
+		r.save(step, append([]string{name}, args...), result)
+	}
+	return result
+}
+
+func (r *Recorder) execute(name string, args ...string) Result {
+	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderrBuf
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return Result{Err: err}
+	}
+	if err := cmd.Start(); err != nil {
+		return Result{Err: err}
+	}
+
+	// The pipe is closed once the command exits, so all of the output has to
+	// be read before waiting on it. Lines can be arbitrarily long, such as the
+	// JSON report of the Gradle init script, so they aren't limited in size.
+	reader := bufio.NewReader(io.TeeReader(stdout, &stdoutBuf))
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			log.Debug(strings.TrimSuffix(line, "\n"))
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("Failed to read command output: %s", err)
+			}
+			break
+		}
+	}
+	err = cmd.Wait()
+
+	return Result{
+		Stdout: stdoutBuf.String(),
+		Stderr: stderrBuf.String(),
+		Err:    err,
+	}
+}
+
+func (r *Recorder) save(step string, command []string, result Result) {
+	dir := filepath.Join(r.SaveDir, step)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalf("Failed to create directory for raw output: %s", err)
+	}
+
+	wd, _ := os.Getwd()
+	env := map[string]string{
+		"GOOS":   runtime.GOOS,
+		"GOARCH": runtime.GOARCH,
+	}
+	for _, key := range recordedEnvVars {
+		if val, ok := os.LookupEnv(key); ok {
+			env[key] = redact(val)
+		}
+	}
+	var redacted []string
+	for _, arg := range command {
+		redacted = append(redacted, redact(arg))
+	}
+	info := Info{
+		Command:     redacted,
+		WorkingDir:  wd,
+		Environment: env,
+		ExitCode:    exitCode(result.Err),
+		Recorded:    time.Now(),
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to serialize command info: %s", err)
+	}
+
+	writeFile(filepath.Join(dir, infoFile), data)
+	writeFile(filepath.Join(dir, stdoutFile), []byte(result.Stdout))
+	writeFile(filepath.Join(dir, stderrFile), []byte(result.Stderr))
+	if result.Output != "" {
+		writeFile(filepath.Join(dir, outputFile), []byte(result.Output))
+	}
+	log.Infof("Saved raw output of %s to %s", strings.Join(redacted, " "), dir)
+}
+
+// Replaces the values of properties that hold credentials
+func redact(value string) string {
+	return secretPropertyRegex.ReplaceAllString(value, "${1}REDACTED")
+}
+
+func (r *Recorder) replay(step string) Result {
+	dir := filepath.Join(r.ReplayDir, step)
+	var info Info
+	if err := json.Unmarshal(readFile(filepath.Join(dir, infoFile)), &info); err != nil {
+		log.Fatalf("Failed to parse recorded command info in %s: %s", dir, err)
+	}
+	log.Infof("Replaying recorded output of %s", strings.Join(info.Command, " "))
+
+	result := Result{
+		Stdout: string(readFile(filepath.Join(dir, stdoutFile))),
+		Stderr: string(readFile(filepath.Join(dir, stderrFile))),
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, outputFile)); err == nil {
+		result.Output = string(data)
+	}
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		log.Debug(line)
+	}
+	if info.ExitCode != 0 {
+		result.Err = fmt.Errorf("recorded command exited with status %d", info.ExitCode)
+	}
+	return result
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+func writeFile(file string, data []byte) {
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		log.Fatalf("Failed to write %s: %s", file, err)
+	}
+}
+
+func readFile(file string) []byte {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatalf("Failed to read recorded output: %s", err)
+	}
+	return data
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sif/models"
+	"sort"
+)
+
+// Identifies an artifact regardless of version. Only one version of each
+// artifact makes it onto the classpath, and the entries for the others still
+// say what pulls the artifact in.
+func artifactKey(dep *models.Dependency) string {
+	return fmt.Sprintf("%s:%s:%s", dep.GroupId, dep.ArtifactId, dep.Classifier)
+}
+
+// Turns the analyzed tree upside down. Each unique dependency becomes a root,
+// largest first, and its children are the dependencies that pull it in, all the
+// way up to the top-level dependencies. Entries omitted in favor of another
+// version count towards the version that was picked. Sizes are the ones from
+// the regular tree, so a root's total is still what it brings in with it.
+func reverseDependencies(analyzedDeps []AnalyzedDependency) []AnalyzedDependency {
+	first := map[string]*AnalyzedDependency{}
+	dependents := map[string][]*AnalyzedDependency{}
+	var order []string
+	var walk func(deps []AnalyzedDependency)
+	walk = func(deps []AnalyzedDependency) {
+		for i := range deps {
+			entry := &deps[i]
+			key := artifactKey(entry.Dependency)
+			if _, ok := first[key]; !ok && !entry.Dependency.Omitted() {
+				first[key] = entry
+				order = append(order, key)
+			}
+			if entry.Parent != nil {
+				parent := first[artifactKey(entry.Parent.Dependency)]
+				if !containsEntry(dependents[key], parent) {
+					dependents[key] = append(dependents[key], parent)
+				}
+			}
+			walk(*entry.Children)
+		}
+	}
+	walk(analyzedDeps)
+
+	roots := make([]AnalyzedDependency, len(order))
+	for i, key := range order {
+		roots[i] = AnalyzedDependency{Dependency: first[key].Dependency, TotalSize: first[key].TotalSize}
+	}
+	sort.SliceStable(roots, func(i, j int) bool {
+		return roots[i].Dependency.Size > roots[j].Dependency.Size
+	})
+
+	// The children are only added once the roots are in place, since they
+	// point back at their parents
+	var expand func(node *AnalyzedDependency)
+	expand = func(node *AnalyzedDependency) {
+		var children []AnalyzedDependency
+		for _, dependent := range dependents[artifactKey(node.Dependency)] {
+			if onPath(node, dependent.Dependency) {
+				continue
+			}
+			children = append(children, AnalyzedDependency{
+				Dependency: dependent.Dependency,
+				Parent:     node,
+				Depth:      node.Depth + 1,
+				TotalSize:  dependent.TotalSize,
+			})
+		}
+		node.Children = &children
+		for i := range children {
+			expand(&children[i])
+		}
+	}
+	for i := range roots {
+		expand(&roots[i])
+	}
+	return roots
+}
+
+func containsEntry(entries []*AnalyzedDependency, entry *AnalyzedDependency) bool {
+	for _, e := range entries {
+		if e == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the dependency is already the node or one of its parents,
+// which keeps a build that lists two dependencies under each other from
+// looping forever
+func onPath(node *AnalyzedDependency, dep *models.Dependency) bool {
+	key := artifactKey(dep)
+	for ptr := node; ptr != nil; ptr = ptr.Parent {
+		if artifactKey(ptr.Dependency) == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"path/filepath"
+	"sif/maven"
+	"sif/models"
+	"strconv"
+	"strings"
+)
+
+// SBOMImport analyzes the components listed in a CycloneDX or SPDX SBOM, for
+// projects that ship one but can't be built. Maven components are sized from
+// the local repository and npm packages from the npm cache. Anything that
+// can't be found is sized from what sif recorded in the SBOM when it wrote it,
+// if it did.
+type SBOMImport struct {
+	RootCtx  models.RootCtx
+	Repo     string
+	NpmCache string
+}
+
+// sbomComponent is a component listed in an SBOM, in either format
+type sbomComponent struct {
+	ref string
+	dep models.Dependency
+	// The package URL type, such as maven or npm, and namespace it was listed
+	// with, if it had one
+	kind      string
+	namespace string
+	// The sif:size property, if it has one
+	size string
+}
+
+// sbomGraph is what is read from an SBOM: the project it describes, its
+// components and what each of them depends on
+type sbomGraph struct {
+	name       string
+	version    string
+	root       string
+	components []sbomComponent
+	dependsOn  map[string][]string
+}
+
+// Splits a package URL such as pkg:maven/group/artifact@version?type=jar into
+// its parts. Only the last part of the namespace is kept, which is all Maven
+// and npm have.
+func parsePackageURL(purl string) (kind, namespace, name, version string, qualifiers url.Values, ok bool) {
+	if !strings.HasPrefix(purl, "pkg:") {
+		return "", "", "", "", nil, false
+	}
+	rest := strings.TrimPrefix(purl, "pkg:")
+	if i := strings.Index(rest, "#"); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.Index(rest, "?"); i >= 0 {
+		qualifiers, _ = url.ParseQuery(rest[i+1:])
+		rest = rest[:i]
+	}
+	if i := strings.LastIndex(rest, "@"); i > strings.LastIndex(rest, "/") {
+		version, _ = url.PathUnescape(rest[i+1:])
+		rest = rest[:i]
+	}
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	if len(parts) < 2 {
+		return "", "", "", "", nil, false
+	}
+	kind = parts[0]
+	name, _ = url.PathUnescape(parts[len(parts)-1])
+	if len(parts) > 2 {
+		namespace, _ = url.PathUnescape(parts[len(parts)-2])
+	}
+	return kind, namespace, name, version, qualifiers, true
+}
+
+// Returns the component a package URL points to. Without one, the group, name
+// and version are used as they are.
+func toSBOMComponent(ref, purl, group, name, version string) sbomComponent {
+	c := sbomComponent{ref: ref, dep: models.Dependency{GroupId: group, ArtifactId: name, Version: version}}
+	kind, namespace, purlName, purlVersion, qualifiers, ok := parsePackageURL(purl)
+	if !ok {
+		return c
+	}
+	c.dep = models.Dependency{GroupId: namespace, ArtifactId: purlName, Version: purlVersion}
+	c.kind = kind
+	c.namespace = namespace
+	if kind == "maven" {
+		c.dep.Classifier = qualifiers.Get("classifier")
+		c.dep.Extension = maven.TypeExtension(qualifiers.Get("type"))
+	} else if namespace == "" {
+		// Packages such as unscoped npm ones only have a name, so they are
+		// shown as npm:name:version
+		c.dep.GroupId = kind
+	}
+	return c
+}
+
+func fromCycloneDX(bom cdxBom) sbomGraph {
+	graph := sbomGraph{
+		name:      bom.Metadata.Component.Name,
+		version:   bom.Metadata.Component.Version,
+		root:      bom.Metadata.Component.BomRef,
+		dependsOn: map[string][]string{},
+	}
+	for _, component := range bom.Components {
+		c := toSBOMComponent(component.BomRef, component.Purl, component.Group, component.Name, component.Version)
+		if c.ref == "" {
+			c.ref = component.Purl
+		}
+		for _, property := range component.Properties {
+			switch {
+			case property.Name == "sif:size":
+				c.size = property.Value
+			case property.Name == "sif:status" && property.Value == string(models.StatusProject):
+				c.dep.Status = models.StatusProject
+			}
+		}
+		graph.components = append(graph.components, c)
+	}
+
+	for _, dependency := range bom.Dependencies {
+		graph.dependsOn[dependency.Ref] = append(graph.dependsOn[dependency.Ref], dependency.DependsOn...)
+	}
+	var walk func(dependencies []cdxXMLDependency)
+	walk = func(dependencies []cdxXMLDependency) {
+		for _, dependency := range dependencies {
+			for _, child := range dependency.DependsOn {
+				graph.dependsOn[dependency.Ref] = append(graph.dependsOn[dependency.Ref], child.Ref)
+			}
+			walk(dependency.DependsOn)
+		}
+	}
+	walk(bom.XMLDependencies)
+	return graph
+}
+
+func fromSPDX(doc spdxDocument) sbomGraph {
+	graph := sbomGraph{name: doc.Name, dependsOn: map[string][]string{}}
+	for _, relationship := range doc.Relationships {
+		switch relationship.Type {
+		case "DESCRIBES":
+			if relationship.Element == doc.SPDXID && graph.root == "" {
+				graph.root = relationship.RelatedElement
+			}
+		case "DEPENDS_ON":
+			graph.dependsOn[relationship.Element] = append(graph.dependsOn[relationship.Element], relationship.RelatedElement)
+		case "DEPENDENCY_OF":
+			graph.dependsOn[relationship.RelatedElement] = append(graph.dependsOn[relationship.RelatedElement], relationship.Element)
+		}
+	}
+
+	for _, pkg := range doc.Packages {
+		if pkg.SPDXID == graph.root {
+			graph.name = pkg.Name
+			graph.version = pkg.VersionInfo
+			continue
+		}
+		var purl string
+		for _, ref := range pkg.ExternalRefs {
+			if ref.Type == "purl" {
+				purl = ref.Locator
+			}
+		}
+		group, name := "", pkg.Name
+		if i := strings.LastIndex(pkg.Name, ":"); i >= 0 {
+			group, name = pkg.Name[:i], pkg.Name[i+1:]
+		}
+		c := toSBOMComponent(pkg.SPDXID, purl, group, name, pkg.VersionInfo)
+		if purl == "" && strings.HasPrefix(pkg.Name, "project ") {
+			c.dep = models.Dependency{ArtifactId: strings.TrimPrefix(pkg.Name, "project "), Status: models.StatusProject}
+		}
+		graph.components = append(graph.components, c)
+	}
+	return graph
+}
+
+// Reads a CycloneDX document, as JSON or XML, or an SPDX document as JSON
+func parseSBOM(data []byte) (sbomGraph, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		var bom cdxBom
+		if err := xml.Unmarshal(data, &bom); err != nil {
+			return sbomGraph{}, fmt.Errorf("unable to read CycloneDX XML: %s", err)
+		}
+		return fromCycloneDX(bom), nil
+	}
+
+	var format struct {
+		BomFormat   string `json:"bomFormat"`
+		SPDXVersion string `json:"spdxVersion"`
+	}
+	if err := json.Unmarshal(data, &format); err != nil {
+		return sbomGraph{}, fmt.Errorf("not a CycloneDX or SPDX document: %s", err)
+	}
+	switch {
+	case format.BomFormat == "CycloneDX":
+		var bom cdxBom
+		if err := json.Unmarshal(data, &bom); err != nil {
+			return sbomGraph{}, fmt.Errorf("unable to read CycloneDX JSON: %s", err)
+		}
+		return fromCycloneDX(bom), nil
+	case strings.HasPrefix(format.SPDXVersion, "SPDX-2."):
+		var doc spdxDocument
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return sbomGraph{}, fmt.Errorf("unable to read SPDX JSON: %s", err)
+		}
+		return fromSPDX(doc), nil
+	case format.SPDXVersion != "":
+		return sbomGraph{}, fmt.Errorf("unsupported SPDX version %s", format.SPDXVersion)
+	}
+	return sbomGraph{}, fmt.Errorf("not a CycloneDX or SPDX document")
+}
+
+// Turns the graph into a tree the way Maven does, with each component listed
+// once, under the first thing that pulls it in at the shallowest depth.
+// Components that nothing pulls in are added at the top level.
+func (g *sbomGraph) toTree(deps map[string]models.Dependency) []models.Dependency {
+	placed := map[string]bool{}
+	children := map[string][]string{}
+	var top, queue []string
+	place := func(ref string) bool {
+		if _, ok := deps[ref]; !ok || placed[ref] {
+			return false
+		}
+		placed[ref] = true
+		queue = append(queue, ref)
+		return true
+	}
+	drain := func() {
+		for len(queue) > 0 {
+			parent := queue[0]
+			queue = queue[1:]
+			for _, child := range g.dependsOn[parent] {
+				if place(child) {
+					children[parent] = append(children[parent], child)
+				}
+			}
+		}
+	}
+	addTopLevel := func(refs []string) {
+		for _, ref := range refs {
+			if place(ref) {
+				top = append(top, ref)
+			}
+		}
+		drain()
+	}
+
+	var all []string
+	for _, c := range g.components {
+		all = append(all, c.ref)
+	}
+	if roots, ok := g.dependsOn[g.root]; ok && g.root != "" {
+		addTopLevel(roots)
+	} else {
+		dependents := map[string]bool{}
+		for _, refs := range g.dependsOn {
+			for _, ref := range refs {
+				dependents[ref] = true
+			}
+		}
+		var roots []string
+		for _, ref := range all {
+			if !dependents[ref] {
+				roots = append(roots, ref)
+			}
+		}
+		addTopLevel(roots)
+	}
+	// Anything left is only pulled in by something else that is left, such as
+	// a cycle, so each one is added in turn
+	for _, ref := range all {
+		addTopLevel([]string{ref})
+	}
+
+	var build func(ref string) models.Dependency
+	build = func(ref string) models.Dependency {
+		dep := deps[ref]
+		dep.Children = []models.Dependency{}
+		for _, child := range children[ref] {
+			dep.Children = append(dep.Children, build(child))
+		}
+		return dep
+	}
+	result := []models.Dependency{}
+	for _, ref := range top {
+		result = append(result, build(ref))
+	}
+	return result
+}
+
+func (s *SBOMImport) Analyze(file string) models.Project {
+	data, err := readFileOrStdin(file)
+	if err != nil {
+		log.Fatalf("Failed to read SBOM from %s: %s", file, err)
+	}
+	graph, err := parseSBOM(data)
+	if err != nil {
+		log.Fatalf("Failed to read SBOM from %s: %s", file, err)
+	}
+
+	repo := s.Repo
+	if repo == "" {
+		repo = defaultMavenRepo
+	}
+	m := maven.Maven{RootCtx: s.RootCtx, MavenRepo: resolvePath(repo)}
+	npmCache := s.NpmCache
+	if npmCache == "" {
+		npmCache = defaultNpmCache
+	}
+	npmCache = resolvePath(npmCache)
+
+	deps := map[string]models.Dependency{}
+	for _, c := range graph.components {
+		dep := c.dep
+		switch {
+		case dep.Status == models.StatusProject:
+		case c.kind == "maven":
+			dep = m.DetermineFileSize(&dep)
+		case c.kind == "npm":
+			determineNpmSize(npmCache, &dep, c.namespace, dep.ArtifactId)
+		default:
+			dep.Status = models.StatusMissing
+		}
+		if dep.Unresolved() && c.size != "" {
+			if size, err := strconv.ParseUint(c.size, 10, 64); err == nil {
+				dep.Size = size
+				dep.Status = models.StatusFound
+				dep.SearchedPaths = nil
+			} else {
+				log.Warnf("Ignoring size %s of %s: %s", c.size, dep.Coordinates(), err)
+			}
+		}
+		if _, ok := deps[c.ref]; !ok {
+			deps[c.ref] = dep
+		}
+	}
+
+	name := graph.name
+	if name == "" {
+		name = filepath.Base(file)
+	}
+	return models.Project{
+		Name:         name,
+		Version:      graph.version,
+		Dependencies: graph.toTree(deps),
+	}
+}
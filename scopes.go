@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"sif/maven"
+	"sif/models"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// scopedProject is the result of analyzing a project with one scope
+type scopedProject struct {
+	Scope   string
+	Project models.Project
+}
+
+// scopeRow is a dependency along with the size it adds to each scope it is in
+type scopeRow struct {
+	Dependency *models.Dependency
+	Sizes      map[string]uint64
+	TestOnly   bool
+}
+
+// scopeMatrix compares the classpath of a project across several scopes
+type scopeMatrix struct {
+	Scopes []string
+	Rows   []scopeRow
+	Totals map[string]uint64
+}
+
+type jsonScopeRow struct {
+	GroupId    string            `json:"groupId"`
+	ArtifactId string            `json:"artifactId"`
+	Version    string            `json:"version"`
+	Classifier string            `json:"classifier,omitempty"`
+	Sizes      map[string]uint64 `json:"sizes"`
+	TestOnly   bool              `json:"testOnly"`
+}
+
+type jsonScopeMatrix struct {
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	Scopes       []string          `json:"scopes"`
+	Totals       map[string]uint64 `json:"totals"`
+	Dependencies []jsonScopeRow    `json:"dependencies"`
+}
+
+// Analyzes the project once for each scope. When saving or replaying raw
+// output, each scope gets its own subdirectory so the runs don't overwrite
+// each other.
+func analyzeScopes(m maven.Maven, scopes []string) []scopedProject {
+	var result []scopedProject
+	for _, scope := range scopes {
+		scoped := m
+		scoped.Scope = scope
+		if m.RootCtx.SaveRawDir != "" {
+			scoped.RootCtx.SaveRawDir = filepath.Join(m.RootCtx.SaveRawDir, scope)
+		}
+		if m.RootCtx.ReplayDir != "" {
+			scoped.RootCtx.ReplayDir = filepath.Join(m.RootCtx.ReplayDir, scope)
+		}
+		log.Infof("Analyzing %s scope", scope)
+		result = append(result, scopedProject{Scope: scope, Project: scoped.Analyze()})
+	}
+	return result
+}
+
+// Builds the matrix of dependency sizes per scope. Each dependency is counted
+// once per scope, however many times it shows up in the tree, and rows are
+// sorted with the largest dependencies first.
+func buildScopeMatrix(projects []scopedProject) scopeMatrix {
+	matrix := scopeMatrix{Totals: map[string]uint64{}}
+	rows := map[string]*scopeRow{}
+	var order []string
+	for _, scoped := range projects {
+		matrix.Scopes = append(matrix.Scopes, scoped.Scope)
+		var walk func(deps []models.Dependency)
+		walk = func(deps []models.Dependency) {
+			for i := range deps {
+				dep := &deps[i]
+				if dep.Omitted() {
+					continue
+				}
+				key := fmt.Sprintf("%s:%s:%s:%s", dep.GroupId, dep.ArtifactId, dep.Version, dep.Classifier)
+				row, ok := rows[key]
+				if !ok {
+					row = &scopeRow{Dependency: dep, Sizes: map[string]uint64{}}
+					rows[key] = row
+					order = append(order, key)
+				}
+				if _, ok := row.Sizes[scoped.Scope]; !ok {
+					row.Sizes[scoped.Scope] = dep.Size
+					matrix.Totals[scoped.Scope] += dep.Size
+				}
+				walk(dep.Children)
+			}
+		}
+		walk(scoped.Project.Dependencies)
+	}
+
+	for _, key := range order {
+		row := rows[key]
+		_, inTest := row.Sizes["test"]
+		row.TestOnly = inTest && len(row.Sizes) == 1 && len(matrix.Scopes) > 1
+		matrix.Rows = append(matrix.Rows, *row)
+	}
+	sort.SliceStable(matrix.Rows, func(i, j int) bool {
+		return matrix.Rows[i].Dependency.Size > matrix.Rows[j].Dependency.Size
+	})
+	return matrix
+}
+
+func printScopes(projects []scopedProject) {
+	if rootCtx.TUI {
+		log.Fatalf("--tui can't be used with --scopes")
+	}
+	switch rootCtx.OutputFormat {
+	case OutputTree:
+		printScopesTable(projects)
+	case OutputJSON:
+		printScopesJSON(projects, os.Stdout)
+	default:
+		log.Fatalf("Output format %s is not supported for multiple scopes", rootCtx.OutputFormat)
+	}
+
+	unresolved := 0
+	for _, scoped := range projects {
+		unresolved += len(unresolvedDependencies(scoped.Project))
+	}
+	if rootCtx.Strict && unresolved > 0 {
+		log.Fatalf("%d artifacts could not be found", unresolved)
+	}
+}
+
+func printScopesTable(projects []scopedProject) {
+	matrix := buildScopeMatrix(projects)
+	project := projects[0].Project
+	log.Infof("Project: %s (%s)", project.Name, project.Version)
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Dependency\t%s\tTest only\t\n", strings.Join(matrix.Scopes, "\t"))
+	for _, row := range matrix.Rows {
+		var cells []string
+		for _, scope := range matrix.Scopes {
+			if size, ok := row.Sizes[scope]; ok {
+				cells = append(cells, humanize.Bytes(size))
+			} else {
+				cells = append(cells, "-")
+			}
+		}
+		testOnly := ""
+		if row.TestOnly {
+			testOnly = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t\n", row.Dependency.Coordinates(), strings.Join(cells, "\t"), testOnly)
+	}
+	var totals []string
+	for _, scope := range matrix.Scopes {
+		totals = append(totals, humanize.Bytes(matrix.Totals[scope]))
+	}
+	fmt.Fprintf(w, "Total\t%s\t\t\n", strings.Join(totals, "\t"))
+	w.Flush()
+
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		log.Info(strings.TrimRight(line, " "))
+	}
+}
+
+func printScopesJSON(projects []scopedProject, w io.Writer) {
+	matrix := buildScopeMatrix(projects)
+	project := projects[0].Project
+	result := jsonScopeMatrix{
+		Name:         project.Name,
+		Version:      project.Version,
+		Scopes:       matrix.Scopes,
+		Totals:       matrix.Totals,
+		Dependencies: []jsonScopeRow{},
+	}
+	for _, row := range matrix.Rows {
+		result.Dependencies = append(result.Dependencies, jsonScopeRow{
+			GroupId:    row.Dependency.GroupId,
+			ArtifactId: row.Dependency.ArtifactId,
+			Version:    row.Dependency.Version,
+			Classifier: row.Dependency.Classifier,
+			Sizes:      row.Sizes,
+			TestOnly:   row.TestOnly,
+		})
+	}
+	writeJSON(w, result)
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The largest build file or saved output that can be uploaded
+const maxUploadSize = 32 << 20
+
+// The number of analyses the server keeps by default
+const defaultMaxAnalyses = 100
+
+// analysis is the result of analyzing a project for the server. Requests for
+// the same project wait for the first one and share its result. Everything the
+// analysis writes to disk is kept in a directory of the cache named after its
+// ID, which is removed when the analysis is dropped.
+type analysis struct {
+	ID   string
+	Tool string
+	Path string
+	done chan struct{}
+	JSON []byte
+	HTML []byte
+	Err  string
+	// An uploaded file that is written to Path before the analysis runs
+	upload []byte
+	// When the analysis was last asked for, as a count of requests
+	lastUsed uint64
+}
+
+type analysisRequest struct {
+	// A project directory, or the POM or Gradle build file of one
+	Path string `json:"path"`
+	// maven or gradle, or maven-tree or gradle-tree for uploaded output. Found
+	// from the path if it isn't given.
+	Tool string `json:"tool"`
+	// Options passed on to the analyzer, such as --scope runtime
+	Args []string `json:"args"`
+	// Analyzes the project again even if there is a result for it already
+	Refresh bool `json:"refresh"`
+}
+
+type analysisResponse struct {
+	ID    string `json:"id"`
+	Tool  string `json:"tool"`
+	Path  string `json:"path,omitempty"`
+	JSON  string `json:"json"`
+	HTML  string `json:"html"`
+	Error string `json:"error,omitempty"`
+}
+
+// Options that a request may pass on to the analyzer, and whether each one
+// takes a value. Anything that changes what is run, such as --cmd, --settings
+// or -D, is left to whoever runs the server.
+var allowedAnalysisFlags = map[string]bool{
+	"large-threshold": true,
+	"large-deps-only": false,
+	"sort":            true,
+	"max-depth":       true,
+	"include":         true,
+	"exclude":         true,
+	"min-size":        true,
+	"top":             true,
+	"top-by":          true,
+	"collapse":        false,
+	"reverse":         false,
+}
+
+// Options that a request may pass on to the analyzer for a particular tool
+var allowedToolFlags = map[string]map[string]bool{
+	"maven":          {"scope": true, "child": true, "verbose": false},
+	"gradle":         {"configuration": true, "child": true},
+	FormatMavenTree:  {"all-modules": false},
+	FormatGradleTree: {"configuration": true},
+}
+
+// server runs analyses by calling sif itself, so a build that fails can't take
+// the server down with it
+type server struct {
+	sifCommand string
+	cacheDir   string
+	// Projects can only be analyzed by path if they are in this directory
+	root string
+	// Uploaded build files are only run if this is set, since a build can run
+	// anything
+	allowBuildUploads bool
+	// Options given by whoever runs the server that are passed to every
+	// analysis with the tool
+	toolArgs map[string][]string
+	// The least recently used analyses are dropped once there are more than this
+	maxAnalyses int
+
+	mu       sync.Mutex
+	analyses map[string]*analysis
+	requests uint64
+}
+
+func newServeCmd() *cobra.Command {
+	var host, root string
+	var port, maxAnalyses int
+	var allowBuildUploads bool
+	var mavenArgs, gradleArgs []string
+	serveCmd := cobra.Command{
+		Use:   "serve [options]",
+		Short: "Serves analyses over HTTP",
+		Long: "Runs a web server that analyzes projects on request and serves the results as JSON and HTML.\n\n" +
+			"  POST /api/analyses       Analyzes a project. Takes a JSON body with the path of the project, or an\n" +
+			"                           uploaded pom.xml, build.gradle or saved dependency tree in the file field\n" +
+			"  GET  /api/analyses/{id}  The analysis as JSON\n" +
+			"  GET  /analyses/{id}      The analysis as an HTML report\n" +
+			"  GET  /view?path=...      Analyzes the project if needed and redirects to its HTML report\n\n" +
+			"As /view runs the build with a plain GET, any web page open in a browser that can reach the server can\n" +
+			"make it build the projects in --root.",
+		Run: func(cmd *cobra.Command, args []string) {
+			processRootConfig()
+			executable, err := os.Executable()
+			if err != nil {
+				log.Fatalf("Unable to find the sif executable: %s", err)
+			}
+			cacheDir, err := ioutil.TempDir("", "sif-serve-")
+			if err != nil {
+				log.Fatalf("Failed to create cache directory: %s", err)
+			}
+			s := newServer(executable, cacheDir, root)
+			s.allowBuildUploads = allowBuildUploads
+			s.maxAnalyses = maxAnalyses
+			s.toolArgs["maven"] = mavenArgs
+			s.toolArgs["gradle"] = gradleArgs
+
+			address := fmt.Sprintf("%s:%d", host, port)
+			log.Infof("Serving analyses on http://%s", address)
+			log.Fatal(http.ListenAndServe(address, s.handler()))
+		},
+	}
+	serveCmd.PersistentFlags().StringVarP(&host,
+		"host",
+		"",
+		"localhost",
+		"The address to listen on")
+	serveCmd.PersistentFlags().IntVarP(&port,
+		"port",
+		"p",
+		8080,
+		"The port to listen on")
+	serveCmd.PersistentFlags().IntVarP(&maxAnalyses,
+		"max-analyses",
+		"",
+		defaultMaxAnalyses,
+		"The number of analyses to keep, after which the least recently used are dropped")
+	serveCmd.PersistentFlags().StringVarP(&root,
+		"root",
+		"",
+		".",
+		"Only projects in this directory can be analyzed by path")
+	serveCmd.PersistentFlags().BoolVarP(&allowBuildUploads,
+		"allow-build-uploads",
+		"",
+		false,
+		"Runs the build tool on uploaded POMs and Gradle build files, which lets anyone who can reach the server run code on it")
+	serveCmd.PersistentFlags().StringArrayVarP(&mavenArgs,
+		"maven-arg",
+		"",
+		nil,
+		"An option to pass to every Maven analysis, such as --repo=/srv/m2, may be repeated")
+	serveCmd.PersistentFlags().StringArrayVarP(&gradleArgs,
+		"gradle-arg",
+		"",
+		nil,
+		"An option to pass to every Gradle analysis, such as --cache=/srv/gradle, may be repeated")
+	return &serveCmd
+}
+
+func newServer(sifCommand, cacheDir, root string) *server {
+	root = resolvePath(root)
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	return &server{
+		sifCommand:  sifCommand,
+		cacheDir:    cacheDir,
+		root:        root,
+		toolArgs:    map[string][]string{},
+		maxAnalyses: defaultMaxAnalyses,
+		analyses:    map[string]*analysis{},
+	}
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/analyses", s.handleSubmit)
+	mux.HandleFunc("/api/analyses/", s.handleView(func(a *analysis) []byte { return a.JSON }, "application/json"))
+	mux.HandleFunc("/analyses/", s.handleView(func(a *analysis) []byte { return a.HTML }, "text/html; charset=utf-8"))
+	mux.HandleFunc("/view", s.handleLink)
+	return mux
+}
+
+// The build files that projects are found by, in the order they are looked for
+var buildFiles = []struct{ name, tool string }{
+	{"pom.xml", "maven"},
+	{"build.gradle", "gradle"},
+	{"build.gradle.kts", "gradle"},
+}
+
+// Works out which analyzer to use for a project from its build file. A
+// directory is replaced with the build file found in it. If the tool is given
+// already, only its build files are looked for.
+func findBuildFile(path, tool string) (string, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", "", err
+	}
+	if !info.IsDir() {
+		switch {
+		case tool != "":
+			return tool, path, nil
+		case strings.HasSuffix(path, ".xml"):
+			return "maven", path, nil
+		case strings.HasSuffix(path, ".gradle"), strings.HasSuffix(path, ".gradle.kts"):
+			return "gradle", path, nil
+		}
+		return "", "", fmt.Errorf("%s is not a POM or Gradle build file", path)
+	}
+	for _, file := range buildFiles {
+		if tool != "" && tool != file.tool {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(path, file.name)); err == nil {
+			return file.tool, filepath.Join(path, file.name), nil
+		}
+	}
+	return "", "", fmt.Errorf("no build file found in %s", path)
+}
+
+// Checks that the options in a request only shape the analysis, and don't
+// change what is run
+func checkArgs(tool string, args []string) error {
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "--") {
+			return fmt.Errorf("unexpected argument %s, only options can be given", args[i])
+		}
+		name := strings.TrimPrefix(args[i], "--")
+		hasValue := strings.Contains(name, "=")
+		if hasValue {
+			name = name[:strings.Index(name, "=")]
+		}
+		takesValue, ok := allowedAnalysisFlags[name]
+		if !ok {
+			takesValue, ok = allowedToolFlags[tool][name]
+		}
+		if !ok {
+			return fmt.Errorf("the --%s option can't be given with a request", name)
+		}
+		if takesValue && !hasValue {
+			if i++; i == len(args) {
+				return fmt.Errorf("the --%s option needs a value", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Resolves a path given in a request, which has to be in the root directory.
+// Relative paths are relative to the root.
+func (s *server) resolveRequestPath(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(s.root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not in %s", path, s.root)
+	}
+	return resolved, nil
+}
+
+// Runs sif and returns what it wrote to stdout. If it fails, the error is the
+// last thing it logged.
+func (s *server) runSif(args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(s.sifCommand, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+			return nil, fmt.Errorf("%s", last)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// Analyzes a project once as JSON and once as HTML. The build tool only runs
+// the first time, and its output is replayed for the second.
+func (s *server) run(a *analysis, args []string) {
+	defer close(a.done)
+	if a.upload != nil {
+		if err := os.MkdirAll(filepath.Dir(a.Path), 0755); err != nil {
+			a.Err = err.Error()
+			return
+		}
+		if err := ioutil.WriteFile(a.Path, a.upload, 0644); err != nil {
+			a.Err = err.Error()
+			return
+		}
+		a.upload = nil
+	}
+
+	var first, second []string
+	switch a.Tool {
+	case "maven", "gradle":
+		rawDir := filepath.Join(s.cacheDir, a.ID, "raw")
+		os.RemoveAll(rawDir)
+		args = append(append([]string{}, s.toolArgs[a.Tool]...), args...)
+		first = append([]string{a.Tool, "--save-raw", rawDir}, args...)
+		second = append([]string{a.Tool, "--replay", rawDir}, args...)
+	case FormatMavenTree, FormatGradleTree:
+		first = append([]string{"parse", "--format", a.Tool}, args...)
+		second = first
+	default:
+		a.Err = fmt.Sprintf("Unknown tool: %s", a.Tool)
+		return
+	}
+
+	log.Infof("Analyzing %s with %s", a.Path, a.Tool)
+	var err error
+	if a.JSON, err = s.runSif(append(first, "-o", OutputJSON, a.Path)...); err != nil {
+		a.Err = err.Error()
+	} else if a.HTML, err = s.runSif(append(second, "-o", OutputHTML, a.Path)...); err != nil {
+		a.Err = err.Error()
+	}
+	if a.Err != "" {
+		log.Warnf("Failed to analyze %s: %s", a.Path, a.Err)
+	}
+}
+
+// Returns the ID of the analysis for a key
+func analysisID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// Returns the analysis for the key, starting it if there isn't one yet
+func (s *server) analyze(key, tool, path string, args []string, upload []byte, refresh bool) *analysis {
+	id := analysisID(key)
+
+	s.mu.Lock()
+	a, ok := s.analyses[id]
+	if ok && refresh {
+		// Let the analysis that is already running finish first
+		s.mu.Unlock()
+		<-a.done
+		s.mu.Lock()
+		if s.analyses[id] == a {
+			ok = false
+		} else {
+			a = s.analyses[id]
+		}
+	}
+	if !ok {
+		a = &analysis{ID: id, Tool: tool, Path: path, upload: upload, done: make(chan struct{})}
+		s.analyses[id] = a
+		go s.run(a, args)
+	}
+	s.use(a)
+	s.mu.Unlock()
+
+	<-a.done
+	return a
+}
+
+// Marks an analysis as used, and drops the least recently used analyses that
+// have finished while there are too many. Must be called with the lock held.
+func (s *server) use(a *analysis) {
+	s.requests++
+	a.lastUsed = s.requests
+	for len(s.analyses) > s.maxAnalyses {
+		var oldest *analysis
+		for _, other := range s.analyses {
+			select {
+			case <-other.done:
+				if other != a && (oldest == nil || other.lastUsed < oldest.lastUsed) {
+					oldest = other
+				}
+			default:
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(s.analyses, oldest.ID)
+		if err := os.RemoveAll(filepath.Join(s.cacheDir, oldest.ID)); err != nil {
+			log.Warnf("Failed to remove the files of analysis %s: %s", oldest.ID, err)
+		}
+	}
+}
+
+// Analyzes a project on the machine the server runs on
+func (s *server) analyzePath(request analysisRequest) (*analysis, error) {
+	if request.Path == "" {
+		return nil, fmt.Errorf("no path given")
+	}
+	path, err := s.resolveRequestPath(request.Path)
+	if err != nil {
+		return nil, err
+	}
+	tool, path, err := findBuildFile(path, request.Tool)
+	if err != nil {
+		return nil, err
+	}
+	// The build file may be a link to somewhere else
+	if path, err = s.resolveRequestPath(path); err != nil {
+		return nil, err
+	}
+	if err := checkArgs(tool, request.Args); err != nil {
+		return nil, err
+	}
+	key := strings.Join(append([]string{tool, path}, request.Args...), "\x00")
+	return s.analyze(key, tool, path, request.Args, nil, request.Refresh), nil
+}
+
+// Analyzes an uploaded build file or saved dependency tree. Uploading the same
+// file again reuses the result.
+func (s *server) analyzeUpload(r *http.Request) (*analysis, error) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("no file uploaded: %s", err)
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(header.Filename)
+	tool := r.FormValue("tool")
+	if tool == "" {
+		switch {
+		case strings.HasSuffix(name, ".xml"):
+			tool = "maven"
+		case strings.HasSuffix(name, ".gradle"), strings.HasSuffix(name, ".gradle.kts"):
+			tool = "gradle"
+		default:
+			return nil, fmt.Errorf("unable to tell how to analyze %s, please give the tool", name)
+		}
+	}
+	if (tool == "maven" || tool == "gradle") && !s.allowBuildUploads {
+		return nil, fmt.Errorf("uploaded build files aren't analyzed on this server, upload a saved dependency tree instead")
+	}
+	// Build files need their usual names for the build tool to pick them up
+	switch {
+	case tool == "maven":
+		name = "pom.xml"
+	case tool == "gradle" && !strings.HasSuffix(name, ".kts"):
+		name = "build.gradle"
+	case tool == "gradle":
+		name = "build.gradle.kts"
+	}
+
+	args := r.Form["args"]
+	if err := checkArgs(tool, args); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	key := strings.Join(append([]string{tool, name, hex.EncodeToString(sum[:])}, args...), "\x00")
+	path := filepath.Join(s.cacheDir, analysisID(key), "upload", name)
+	return s.analyze(key, tool, path, args, content, r.FormValue("refresh") == "true"), nil
+}
+
+func writeResponse(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeJSON(w, value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeResponse(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("analyses are submitted with POST"))
+		return
+	}
+
+	var a *analysis
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err = r.ParseMultipartForm(maxUploadSize); err == nil {
+			a, err = s.analyzeUpload(r)
+		}
+	} else {
+		var request analysisRequest
+		if err = json.NewDecoder(io.LimitReader(r.Body, maxUploadSize)).Decode(&request); err == nil {
+			a, err = s.analyzePath(request)
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	response := analysisResponse{
+		ID:    a.ID,
+		Tool:  a.Tool,
+		JSON:  "/api/analyses/" + a.ID,
+		HTML:  "/analyses/" + a.ID,
+		Error: a.Err,
+	}
+	if !strings.HasPrefix(a.Path, s.cacheDir) {
+		response.Path = a.Path
+	}
+	status := http.StatusOK
+	if a.Err != "" {
+		status = http.StatusUnprocessableEntity
+	}
+	writeResponse(w, status, response)
+}
+
+// Serves one of the views of a finished analysis
+func (s *server) handleView(view func(*analysis) []byte, contentType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		s.mu.Lock()
+		a, ok := s.analyses[id]
+		if ok {
+			s.use(a)
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no analysis with id %s", id))
+			return
+		}
+		<-a.done
+		if a.Err != "" {
+			writeError(w, http.StatusUnprocessableEntity, fmt.Errorf("%s", a.Err))
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(view(a))
+	}
+}
+
+// Gives a link that always leads to the latest analysis of a project, for
+// pages that link to a project rather than to one analysis of it
+func (s *server) handleLink(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	a, err := s.analyzePath(analysisRequest{
+		Path:    query.Get("path"),
+		Tool:    query.Get("tool"),
+		Args:    query["args"],
+		Refresh: query.Get("refresh") == "true",
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	http.Redirect(w, r, "/analyses/"+url.PathEscape(a.ID), http.StatusSeeOther)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"regexp"
+	"sif/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where download locations point to, since the build tools don't report which
+// repository an artifact came from
+const mavenCentral = "https://repo.maven.apache.org/maven2"
+
+// SPDX identifiers can only hold letters, numbers, dots and dashes
+var spdxIdRegex = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	Category string `json:"referenceCategory"`
+	Type     string `json:"referenceType"`
+	Locator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	Element        string `json:"spdxElementId"`
+	Type           string `json:"relationshipType"`
+	RelatedElement string `json:"relatedSpdxElement"`
+}
+
+// Returns when the document was created. SOURCE_DATE_EPOCH can fix the time,
+// so the same project always gives the same document.
+func spdxCreated() time.Time {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			log.Fatalf("Unable to parse SOURCE_DATE_EPOCH %s as a number of seconds", epoch)
+		}
+		return time.Unix(seconds, 0).UTC()
+	}
+	return time.Now().UTC()
+}
+
+func spdxId(parts ...string) string {
+	return "SPDXRef-Package-" + strings.Trim(spdxIdRegex.ReplaceAllString(strings.Join(parts, "-"), "-"), "-")
+}
+
+// Returns the URL the artifact can be downloaded from on Maven Central
+func downloadLocation(dep *models.Dependency) string {
+	if dep.Status == models.StatusProject {
+		return "NOASSERTION"
+	}
+	return fmt.Sprintf("%s/%s/%s/%s/%s",
+		mavenCentral,
+		strings.ReplaceAll(dep.GroupId, ".", "/"),
+		dep.ArtifactId,
+		dep.Version,
+		dep.FileName())
+}
+
+func toSPDX(project models.Project) spdxDocument {
+	graph := buildDependencyGraph(calculateTotalSizes(project))
+
+	projectId := spdxId(project.Name)
+	doc := spdxDocument{
+		SPDXVersion: "SPDX-2.3",
+		DataLicense: "CC0-1.0",
+		SPDXID:      "SPDXRef-DOCUMENT",
+		Name:        fmt.Sprintf("%s-%s", project.Name, project.Version),
+		CreationInfo: spdxCreationInfo{
+			Created:  spdxCreated().Format(time.RFC3339),
+			Creators: []string{"Tool: sif-" + Version},
+		},
+		Packages: []spdxPackage{{
+			Name:             project.Name,
+			SPDXID:           projectId,
+			VersionInfo:      project.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+		}},
+		Relationships: []spdxRelationship{{Element: "SPDXRef-DOCUMENT", Type: "DESCRIBES", RelatedElement: projectId}},
+	}
+
+	ids := map[string]string{}
+	for _, key := range graph.order {
+		dep := graph.deps[key]
+		pkg := spdxPackage{
+			Name:             fmt.Sprintf("%s:%s", dep.GroupId, dep.ArtifactId),
+			SPDXID:           spdxId(dep.GroupId, dep.ArtifactId, dep.Version, dep.Classifier),
+			VersionInfo:      dep.Version,
+			DownloadLocation: downloadLocation(dep),
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+		}
+		if dep.Status == models.StatusProject {
+			pkg.Name = dep.Coordinates()
+			pkg.SPDXID = spdxId("project", dep.ArtifactId)
+		} else {
+			pkg.ExternalRefs = []spdxExternalRef{{Category: "PACKAGE-MANAGER", Type: "purl", Locator: packageURL(dep)}}
+		}
+		if dep.File != "" {
+			if hash := fileSHA256(dep.File); hash != "" {
+				pkg.Checksums = []spdxChecksum{{Algorithm: "SHA256", Value: hash}}
+			}
+		}
+		ids[key] = pkg.SPDXID
+		doc.Packages = append(doc.Packages, pkg)
+	}
+
+	seen := map[string]bool{}
+	dependsOn := func(from, to string) {
+		if from != "" && to != "" && !seen[from+" "+to] {
+			seen[from+" "+to] = true
+			doc.Relationships = append(doc.Relationships, spdxRelationship{Element: from, Type: "DEPENDS_ON", RelatedElement: to})
+		}
+	}
+	for _, root := range graph.roots {
+		dependsOn(projectId, ids[root])
+	}
+	for _, key := range graph.order {
+		for _, child := range graph.children[key] {
+			dependsOn(ids[key], ids[child])
+		}
+	}
+
+	// The namespace has to be unique to this document, so it is made from its
+	// name, creation time and packages
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s", doc.Name, doc.CreationInfo.Created)
+	for _, pkg := range doc.Packages {
+		fmt.Fprintf(hash, " %s", pkg.SPDXID)
+	}
+	doc.DocumentNamespace = fmt.Sprintf("https://github.com/monitorjbl/sif/spdx/%s-%s",
+		spdxIdRegex.ReplaceAllString(doc.Name, "-"), hex.EncodeToString(hash.Sum(nil))[:16])
+	return doc
+}
+
+// Writes an SPDX 2.3 SBOM of the project as JSON
+func printSPDX(project models.Project, w io.Writer) {
+	writeJSON(w, toSPDX(project))
+}
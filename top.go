@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"sif/models"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// What --top can rank dependencies by
+const (
+	// The size of the dependency's own file
+	TopByOwn = "own"
+	// Its own size plus everything it pulls in
+	TopByTotal = "total"
+	// What removing it would save, leaving out what something else still pulls in
+	TopByExclusive = "exclusive"
+)
+
+// topEntry is a unique dependency with the sizes it can be ranked by
+type topEntry struct {
+	Dependency    *models.Dependency
+	TotalSize     uint64
+	ExclusiveSize uint64
+	Paths         int
+}
+
+// Returns the size the entry is ranked by
+func (e *topEntry) rankedSize(by string) uint64 {
+	switch by {
+	case TopByTotal:
+		return e.TotalSize
+	case TopByExclusive:
+		return e.ExclusiveSize
+	default:
+		return e.Dependency.Size
+	}
+}
+
+// dependencyGraph links each unique dependency to the ones it pulls in. Unlike
+// the tree, where a dependency is only listed under the first thing that pulls
+// it in, every edge is kept.
+type dependencyGraph struct {
+	order    []string
+	deps     map[string]*models.Dependency
+	roots    []string
+	children map[string][]string
+	parents  map[string][]string
+}
+
+func buildDependencyGraph(analyzedDeps []AnalyzedDependency) dependencyGraph {
+	graph := dependencyGraph{
+		deps:     map[string]*models.Dependency{},
+		children: map[string][]string{},
+		parents:  map[string][]string{},
+	}
+	edges := map[string]bool{}
+	var walk func(deps []AnalyzedDependency)
+	walk = func(deps []AnalyzedDependency) {
+		for i := range deps {
+			entry := &deps[i]
+			key := artifactKey(entry.Dependency)
+			if _, ok := graph.deps[key]; !ok && !entry.Dependency.Omitted() {
+				graph.deps[key] = entry.Dependency
+				graph.order = append(graph.order, key)
+			}
+			if entry.Parent == nil {
+				graph.roots = append(graph.roots, key)
+			} else if parent := artifactKey(entry.Parent.Dependency); !edges[parent+" "+key] {
+				edges[parent+" "+key] = true
+				graph.children[parent] = append(graph.children[parent], key)
+				graph.parents[key] = append(graph.parents[key], parent)
+			}
+			walk(*entry.Children)
+		}
+	}
+	walk(analyzedDeps)
+	return graph
+}
+
+// Adds up the size of every dependency reachable from the given ones without
+// going through skip
+func (g *dependencyGraph) reachableSize(from []string, skip string) uint64 {
+	var size uint64
+	seen := map[string]bool{skip: true}
+	stack := append([]string{}, from...)
+	for len(stack) > 0 {
+		key := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		dep, ok := g.deps[key]
+		if seen[key] || !ok {
+			continue
+		}
+		seen[key] = true
+		size += dep.Size
+		stack = append(stack, g.children[key]...)
+	}
+	return size
+}
+
+// Counts the paths from the project down to the dependency
+func (g *dependencyGraph) countPaths(key string, counts map[string]int, visiting map[string]bool) int {
+	if count, ok := counts[key]; ok {
+		return count
+	}
+	if visiting[key] {
+		return 0
+	}
+	visiting[key] = true
+	count := 0
+	for _, root := range g.roots {
+		if root == key {
+			count++
+		}
+	}
+	for _, parent := range g.parents[key] {
+		count += g.countPaths(parent, counts, visiting)
+	}
+	visiting[key] = false
+	counts[key] = count
+	return count
+}
+
+// Ranks every unique dependency in the project by the given size, largest
+// first. Returns the ranking along with the size of the whole classpath.
+func rankDependencies(project models.Project, by string) ([]topEntry, uint64) {
+	graph := buildDependencyGraph(calculateTotalSizes(project))
+	classpath := graph.reachableSize(graph.roots, "")
+
+	var entries []topEntry
+	counts := map[string]int{}
+	for _, key := range graph.order {
+		entries = append(entries, topEntry{
+			Dependency:    graph.deps[key],
+			TotalSize:     graph.reachableSize([]string{key}, ""),
+			ExclusiveSize: classpath - graph.reachableSize(graph.roots, key),
+			Paths:         graph.countPaths(key, counts, map[string]bool{}),
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].rankedSize(by) > entries[j].rankedSize(by)
+	})
+	return entries, classpath
+}
+
+func percentOf(size, classpath uint64) float64 {
+	if classpath == 0 {
+		return 0
+	}
+	return math.Round(float64(size)*1000/float64(classpath)) / 10
+}
+
+func printTopTable(project models.Project) {
+	entries, classpath := rankDependencies(project, rootCtx.TopBy)
+	log.Infof("Project: %s (%s)", project.Name, project.Version)
+	unique := len(entries)
+	if len(entries) > rootCtx.Top {
+		entries = entries[:rootCtx.Top]
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Dependency\tOwn\tTotal\tExclusive\t%% of classpath\tPaths\t\n")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.1f%%\t%d\t\n",
+			entry.Dependency.Coordinates(),
+			humanize.Bytes(entry.Dependency.Size),
+			humanize.Bytes(entry.TotalSize),
+			humanize.Bytes(entry.ExclusiveSize),
+			percentOf(entry.rankedSize(rootCtx.TopBy), classpath),
+			entry.Paths)
+	}
+	w.Flush()
+
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		log.Info(strings.TrimRight(line, " "))
+	}
+	log.Infof("Ranked by %s size, out of %s in %d unique dependencies", rootCtx.TopBy, humanize.Bytes(classpath), unique)
+	printUnresolved(project)
+}
+
+type jsonTopEntry struct {
+	GroupId       string  `json:"groupId"`
+	ArtifactId    string  `json:"artifactId"`
+	Version       string  `json:"version"`
+	Classifier    string  `json:"classifier,omitempty"`
+	Status        string  `json:"status"`
+	Size          uint64  `json:"size"`
+	TotalSize     uint64  `json:"totalSize"`
+	ExclusiveSize uint64  `json:"exclusiveSize"`
+	Percent       float64 `json:"percentOfClasspath"`
+	Paths         int     `json:"paths"`
+}
+
+type jsonTop struct {
+	Name          string         `json:"name"`
+	Version       string         `json:"version"`
+	ClasspathSize uint64         `json:"classpathSize"`
+	RankedBy      string         `json:"rankedBy"`
+	Dependencies  []jsonTopEntry `json:"dependencies"`
+}
+
+func toJSONTop(project models.Project) jsonTop {
+	entries, classpath := rankDependencies(project, rootCtx.TopBy)
+	if len(entries) > rootCtx.Top {
+		entries = entries[:rootCtx.Top]
+	}
+	return jsonTop{
+		Name:          project.Name,
+		Version:       project.Version,
+		ClasspathSize: classpath,
+		RankedBy:      rootCtx.TopBy,
+		Dependencies:  toJSONTopEntries(entries, classpath, rootCtx.TopBy),
+	}
+}
+
+func toJSONTopEntries(entries []topEntry, classpath uint64, by string) []jsonTopEntry {
+	result := []jsonTopEntry{}
+	for _, entry := range entries {
+		dep := entry.Dependency
+		result = append(result, jsonTopEntry{
+			GroupId:       dep.GroupId,
+			ArtifactId:    dep.ArtifactId,
+			Version:       dep.Version,
+			Classifier:    dep.Classifier,
+			Status:        string(dep.Status),
+			Size:          dep.Size,
+			TotalSize:     entry.TotalSize,
+			ExclusiveSize: entry.ExclusiveSize,
+			Percent:       percentOf(entry.rankedSize(by), classpath),
+			Paths:         entry.Paths,
+		})
+	}
+	return result
+}
+
+func printTopJSON(project models.Project, w io.Writer) {
+	writeJSON(w, toJSONTop(project))
+}
+
+func printModulesTopJSON(projects []models.Project, w io.Writer) {
+	result := []jsonTop{}
+	for _, project := range projects {
+		result = append(result, toJSONTop(project))
+	}
+	writeJSON(w, result)
+}
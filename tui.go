@@ -0,0 +1,532 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/fatih/color"
+	"io"
+	"regexp"
+	"sif/models"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The orders the explorer cycles through with s. The empty order is the one
+// the build tool listed the dependencies in.
+var explorerSorts = []string{"", SortBySize, SortByTotal, SortByName}
+
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]`)
+
+// explorer is the state of the interactive tree opened with --tui. It only
+// deals in keys and lines of text, so the terminal handling lives elsewhere.
+type explorer struct {
+	project   models.Project
+	roots     []AnalyzedDependency
+	totalDeps int
+	totalSize uint64
+
+	expanded map[*AnalyzedDependency]bool
+	rows     []*AnalyzedDependency
+	cursor   int
+	offset   int
+	sort     int
+	showFile bool
+
+	// Search input, and the last search for n and N
+	searching bool
+	input     string
+	query     string
+	message   string
+
+	// The paths to the selected dependency, while they are shown
+	whyPaths  [][]*AnalyzedDependency
+	whyCursor int
+
+	quit bool
+}
+
+func newExplorer(project models.Project) *explorer {
+	e := &explorer{
+		project:  project,
+		roots:    calculateTotalSizes(project),
+		expanded: map[*AnalyzedDependency]bool{},
+	}
+	e.totalDeps, e.totalSize = countDependencies(e.roots)
+	e.refresh()
+	return e
+}
+
+// Returns the entries in the order they are currently sorted in. The tree
+// itself isn't reordered, since children point back at their parents.
+func (e *explorer) sorted(deps []AnalyzedDependency) []*AnalyzedDependency {
+	var result []*AnalyzedDependency
+	for i := range deps {
+		result = append(result, &deps[i])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		switch explorerSorts[e.sort] {
+		case SortBySize:
+			return result[i].Dependency.Size > result[j].Dependency.Size
+		case SortByTotal:
+			return result[i].TotalSize > result[j].TotalSize
+		case SortByName:
+			return result[i].Dependency.Coordinates() < result[j].Dependency.Coordinates()
+		default:
+			return false
+		}
+	})
+	return result
+}
+
+// Lists every entry in the tree in display order, expanded or not
+func (e *explorer) allEntries() []*AnalyzedDependency {
+	var result []*AnalyzedDependency
+	var walk func(deps []AnalyzedDependency)
+	walk = func(deps []AnalyzedDependency) {
+		for _, entry := range e.sorted(deps) {
+			result = append(result, entry)
+			walk(*entry.Children)
+		}
+	}
+	walk(e.roots)
+	return result
+}
+
+// Rebuilds the visible rows, keeping the cursor on the same entry if it is
+// still shown
+func (e *explorer) refresh() {
+	var selected *AnalyzedDependency
+	if e.cursor < len(e.rows) {
+		selected = e.rows[e.cursor]
+	}
+
+	e.rows = nil
+	var walk func(deps []AnalyzedDependency)
+	walk = func(deps []AnalyzedDependency) {
+		for _, entry := range e.sorted(deps) {
+			e.rows = append(e.rows, entry)
+			if e.expanded[entry] {
+				walk(*entry.Children)
+			}
+		}
+	}
+	walk(e.roots)
+	e.selectEntry(selected)
+}
+
+// Moves the cursor to the entry, expanding everything above it
+func (e *explorer) reveal(entry *AnalyzedDependency) {
+	for ptr := entry.Parent; ptr != nil; ptr = ptr.Parent {
+		e.expanded[ptr] = true
+	}
+	e.refresh()
+	e.selectEntry(entry)
+}
+
+func (e *explorer) selectEntry(entry *AnalyzedDependency) {
+	for i, row := range e.rows {
+		if row == entry {
+			e.cursor = i
+			return
+		}
+	}
+	if e.cursor >= len(e.rows) {
+		e.cursor = len(e.rows) - 1
+	}
+	if e.cursor < 0 {
+		e.cursor = 0
+	}
+}
+
+func (e *explorer) selected() *AnalyzedDependency {
+	if len(e.rows) == 0 {
+		return nil
+	}
+	return e.rows[e.cursor]
+}
+
+func (e *explorer) moveCursor(delta int) {
+	e.cursor += delta
+	if e.cursor >= len(e.rows) {
+		e.cursor = len(e.rows) - 1
+	}
+	if e.cursor < 0 {
+		e.cursor = 0
+	}
+}
+
+// Finds the next entry after the selected one whose coordinates contain the
+// query, wrapping around at the end of the tree
+func (e *explorer) search(forward bool) {
+	if e.query == "" {
+		return
+	}
+	entries := e.allEntries()
+	start := 0
+	for i, entry := range entries {
+		if entry == e.selected() {
+			start = i
+		}
+	}
+	query := strings.ToLower(e.query)
+	for n := 1; n <= len(entries); n++ {
+		i := (start + n) % len(entries)
+		if !forward {
+			i = (start - n + len(entries)) % len(entries)
+		}
+		if strings.Contains(strings.ToLower(entries[i].Dependency.Coordinates()), query) {
+			e.reveal(entries[i])
+			e.message = ""
+			return
+		}
+	}
+	e.message = fmt.Sprintf("No dependency matches %s", e.query)
+}
+
+// Handles a key read from the terminal. Special keys are named, everything else
+// is the character that was typed.
+func (e *explorer) handleKey(key string) {
+	e.message = ""
+	switch {
+	case e.searching:
+		e.handleSearchKey(key)
+	case e.whyPaths != nil:
+		e.handleWhyKey(key)
+	default:
+		e.handleTreeKey(key)
+	}
+}
+
+func (e *explorer) handleSearchKey(key string) {
+	switch key {
+	case "enter":
+		e.searching = false
+		e.query = e.input
+		e.search(true)
+	case "esc", "ctrl-c":
+		e.searching = false
+	case "backspace":
+		if e.input != "" {
+			_, size := utf8.DecodeLastRuneInString(e.input)
+			e.input = e.input[:len(e.input)-size]
+		}
+	default:
+		if utf8.RuneCountInString(key) == 1 {
+			e.input += key
+		}
+	}
+}
+
+func (e *explorer) handleWhyKey(key string) {
+	switch key {
+	case "up", "k":
+		if e.whyCursor > 0 {
+			e.whyCursor--
+		}
+	case "down", "j":
+		if e.whyCursor < len(e.whyPaths)-1 {
+			e.whyCursor++
+		}
+	case "enter":
+		path := e.whyPaths[e.whyCursor]
+		e.whyPaths = nil
+		e.reveal(path[len(path)-1])
+	case "ctrl-c":
+		e.quit = true
+	default:
+		e.whyPaths = nil
+	}
+}
+
+func (e *explorer) handleTreeKey(key string) {
+	entry := e.selected()
+	switch key {
+	case "q", "ctrl-c":
+		e.quit = true
+	case "up", "k":
+		e.moveCursor(-1)
+	case "down", "j":
+		e.moveCursor(1)
+	case "pgup":
+		e.moveCursor(-10)
+	case "pgdown":
+		e.moveCursor(10)
+	case "home", "g":
+		e.cursor = 0
+	case "end", "G":
+		e.moveCursor(len(e.rows))
+	case "right", "l", "enter":
+		if entry == nil || len(*entry.Children) == 0 {
+			return
+		}
+		if e.expanded[entry] {
+			e.moveCursor(1)
+		} else {
+			e.expanded[entry] = true
+			e.refresh()
+		}
+	case "left", "h":
+		if entry == nil {
+			return
+		}
+		if e.expanded[entry] {
+			e.expanded[entry] = false
+			e.refresh()
+		} else if entry.Parent != nil {
+			e.selectEntry(entry.Parent)
+		}
+	case " ":
+		if entry != nil && len(*entry.Children) > 0 {
+			e.expanded[entry] = !e.expanded[entry]
+			e.refresh()
+		}
+	case "E":
+		for _, entry := range e.allEntries() {
+			e.expanded[entry] = true
+		}
+		e.refresh()
+	case "C":
+		e.expanded = map[*AnalyzedDependency]bool{}
+		for entry != nil && entry.Parent != nil {
+			entry = entry.Parent
+		}
+		e.refresh()
+		e.selectEntry(entry)
+	case "s":
+		e.sort = (e.sort + 1) % len(explorerSorts)
+		e.refresh()
+	case "t":
+		e.showFile = !e.showFile
+	case "/":
+		e.searching = true
+		e.input = ""
+	case "n":
+		e.search(true)
+	case "N":
+		e.search(false)
+	case "w":
+		if entry == nil || entry.Dependency.Status == models.StatusProject {
+			return
+		}
+		dep := entry.Dependency
+		e.whyPaths = findPaths(e.roots, fmt.Sprintf("%s:%s", dep.GroupId, dep.ArtifactId))
+		e.whyCursor = 0
+	}
+}
+
+// Returns the number of columns the text takes up on the terminal
+func visibleWidth(s string) int {
+	return utf8.RuneCountInString(ansiRegex.ReplaceAllString(s, ""))
+}
+
+// Shortens plain text to fit the given width
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	if width <= 1 {
+		return strings.Repeat("…", width)
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+// Lays out a line with the text on the left, shortened if needed, and the
+// suffix and the size on the right
+func layoutLine(text, suffix, size string, width int, selected bool) string {
+	available := width - visibleWidth(suffix) - visibleWidth(size) - 1
+	if available < 0 {
+		available = 0
+	}
+	text = truncate(text, available)
+	if selected {
+		text = "\x1b[7m" + text + "\x1b[0m"
+	}
+	padding := width - visibleWidth(text) - visibleWidth(suffix) - visibleWidth(size)
+	if padding < 1 {
+		padding = 1
+	}
+	return text + suffix + strings.Repeat(" ", padding) + size
+}
+
+func (e *explorer) sizeText(entry *AnalyzedDependency) string {
+	if entry.Dependency.Omitted() {
+		return ""
+	}
+	size, label := entry.TotalSize, "Total"
+	if e.showFile {
+		size, label = entry.Dependency.Size, "File"
+	}
+	sizeColor := color.New(color.Reset)
+	if size > rootCtx.LargeDependencyThresholdBytes {
+		sizeColor = color.New(color.BgRed)
+	}
+	return sizeColor.Sprintf("%s: %s", label, humanize.Bytes(size))
+}
+
+func (e *explorer) renderRow(entry *AnalyzedDependency, width int, selected bool) string {
+	marker := "  "
+	if len(*entry.Children) > 0 {
+		marker = "▸ "
+		if e.expanded[entry] {
+			marker = "▾ "
+		}
+	}
+	dep := entry.Dependency
+	text := strings.Repeat("    ", entry.Depth) + marker + dep.Coordinates()
+	suffix := statusMarker(dep) + mediationMarker(dep)
+	if dep.Omitted() {
+		text = fmt.Sprintf("%s%s(%s - omitted for conflict with %s)", strings.Repeat("    ", entry.Depth), marker, dep.Coordinates(), dep.OmittedForConflictWith)
+		suffix = ""
+	}
+	return layoutLine(text, suffix, e.sizeText(entry), width, selected)
+}
+
+func (e *explorer) renderWhy(width, height int) []string {
+	dep := e.selected().Dependency
+	lines := []string{
+		truncate(fmt.Sprintf("Paths to %s:%s (enter jumps to the path, any other key goes back)", dep.GroupId, dep.ArtifactId), width),
+	}
+	var selectedLine int
+	for i, path := range e.whyPaths {
+		if i == e.whyCursor {
+			selectedLine = len(lines)
+		}
+		lines = append(lines, "")
+		top := path[0]
+		lines = append(lines, layoutLine(top.Dependency.Coordinates(), statusMarker(top.Dependency), e.sizeText(top), width, i == e.whyCursor))
+		for depth, entry := range path[1:] {
+			text := fmt.Sprintf("%s└── %s", strings.Repeat("     ", depth), entry.Dependency.Coordinates())
+			lines = append(lines, layoutLine(text, statusMarker(entry.Dependency)+mediationMarker(entry.Dependency), "", width, false))
+		}
+	}
+
+	// Scroll so the selected path starts at the top if it wouldn't be on the
+	// screen otherwise
+	if selectedLine >= height-1 {
+		lines = append(lines[:1], lines[selectedLine:]...)
+	}
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return lines
+}
+
+// Draws the screen as lines of text. The first line describes the project and
+// the last one shows the keys, or the search being typed.
+func (e *explorer) render(width, height int) []string {
+	header := truncate(fmt.Sprintf("Project: %s (%s) - %s in %d dependencies",
+		e.project.Name, e.project.Version, humanize.Bytes(e.totalSize), e.totalDeps), width)
+
+	var body []string
+	bodyHeight := height - 2
+	if bodyHeight < 1 {
+		bodyHeight = 1
+	}
+	if e.whyPaths != nil {
+		body = e.renderWhy(width, bodyHeight)
+	} else {
+		if e.cursor < e.offset {
+			e.offset = e.cursor
+		}
+		if e.cursor >= e.offset+bodyHeight {
+			e.offset = e.cursor - bodyHeight + 1
+		}
+		for i := e.offset; i < len(e.rows) && i < e.offset+bodyHeight; i++ {
+			body = append(body, e.renderRow(e.rows[i], width, i == e.cursor))
+		}
+	}
+	for len(body) < bodyHeight {
+		body = append(body, "")
+	}
+
+	sortName := explorerSorts[e.sort]
+	if sortName == "" {
+		sortName = "build"
+	}
+	sizes := "total"
+	if e.showFile {
+		sizes = "file"
+	}
+	status := fmt.Sprintf("←→ collapse/expand  s sort: %s  t sizes: %s  / search  n/N next/previous  w why  q quit", sortName, sizes)
+	switch {
+	case e.searching:
+		status = "/" + e.input
+	case e.message != "":
+		status = e.message
+	}
+	return append(append([]string{header}, body...), truncate(status, width))
+}
+
+// Splits what was read from the terminal into keys. Escape sequences for the
+// arrow and paging keys are given names.
+func parseKeys(input []byte) []string {
+	sequences := map[string]string{
+		"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+		"\x1bOA": "up", "\x1bOB": "down", "\x1bOC": "right", "\x1bOD": "left",
+		"\x1b[5~": "pgup", "\x1b[6~": "pgdown",
+		"\x1b[H": "home", "\x1b[F": "end", "\x1b[1~": "home", "\x1b[4~": "end",
+	}
+	var keys []string
+	s := string(input)
+	for len(s) > 0 {
+		matched := false
+		for sequence, name := range sequences {
+			if strings.HasPrefix(s, sequence) {
+				keys = append(keys, name)
+				s = s[len(sequence):]
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		switch r {
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case 0x1b:
+			keys = append(keys, "esc")
+		case 0x7f, 0x08:
+			keys = append(keys, "backspace")
+		case 0x03:
+			keys = append(keys, "ctrl-c")
+		default:
+			keys = append(keys, string(r))
+		}
+		s = s[size:]
+	}
+	return keys
+}
+
+// Reads keys from the terminal until it is closed
+func readKeys(r io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		for _, key := range parseKeys(buf[:n]) {
+			keys <- key
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Redraws the whole screen
+func drawScreen(w io.Writer, lines []string) {
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+		b.WriteString("\x1b[0m\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	io.WriteString(w, b.String())
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"sif/models"
+)
+
+func runExplorer(project models.Project) {
+	log.Fatalf("--tui is not supported on this platform")
+}
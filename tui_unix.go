@@ -0,0 +1,83 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"bufio"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/signal"
+	"sif/models"
+)
+
+// Puts the terminal in raw mode, so keys are read as they are pressed and not
+// echoed. Returns the previous settings to restore.
+func makeRaw(fd int) (*unix.Termios, error) {
+	old, err := unix.IoctlGetTermios(fd, getTermios)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, setTermios, &raw); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+func terminalSize(fd int) (int, int) {
+	size, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil || size.Col == 0 || size.Row == 0 {
+		return 80, 24
+	}
+	return int(size.Col), int(size.Row)
+}
+
+// Opens the explorer full screen and runs it until it is quit
+func runExplorer(project models.Project) {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if _, err := unix.IoctlGetTermios(out, getTermios); err != nil {
+		log.Fatalf("--tui needs to be run in a terminal")
+	}
+	old, err := makeRaw(in)
+	if err != nil {
+		log.Fatalf("--tui needs to be run in a terminal: %s", err)
+	}
+	defer unix.IoctlSetTermios(in, setTermios, old)
+
+	// Switch to the alternate screen and hide the cursor while exploring
+	w := bufio.NewWriter(os.Stdout)
+	w.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		w.WriteString("\x1b[?25h\x1b[?1049l")
+		w.Flush()
+	}()
+
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, unix.SIGWINCH)
+	defer signal.Stop(resized)
+	keys := make(chan string)
+	go readKeys(os.Stdin, keys)
+
+	e := newExplorer(project)
+	for !e.quit {
+		drawScreen(w, e.render(terminalSize(out)))
+		w.Flush()
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				return
+			}
+			e.handleKey(key)
+		case <-resized:
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"github.com/dustin/go-humanize"
+	"github.com/fatih/color"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"io"
+	"os"
+	"sif/models"
+	"strings"
+)
+
+// The dependency the why command looks for, as group:artifact[:version]
+var whyQuery string
+
+var whyReporter = reporter{
+	project: func(project models.Project) {
+		printWhy([]models.Project{project})
+	},
+	modules: printWhy,
+	scopes:  printWhyScopes,
+}
+
+type jsonWhyPath struct {
+	TopLevelTotalSize uint64   `json:"topLevelTotalSize"`
+	Path              []string `json:"path"`
+	Omitted           bool     `json:"omitted,omitempty"`
+}
+
+type jsonWhy struct {
+	Name    string        `json:"name"`
+	Version string        `json:"version"`
+	Scope   string        `json:"scope,omitempty"`
+	Query   string        `json:"query"`
+	Paths   []jsonWhyPath `json:"paths"`
+}
+
+// The why command takes the dependency to look for ahead of the build tool,
+// so its arguments are split up by hand and the rest are handed to the same
+// commands that produce the normal report.
+func newWhyCmd() *cobra.Command {
+	whyCmd := cobra.Command{
+		Use:   "why group:artifact[:version] maven|gradle|parse [options] path",
+		Short: "Shows every path from the project to a dependency",
+		Long: "Shows every path from the project to a dependency, along with the total size of the top-level\n" +
+			"dependency each path starts with. The options are the same as for the maven, gradle and parse commands.",
+		DisableFlagParsing: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 2 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+				cmd.Help()
+				return
+			}
+			whyQuery = args[0]
+			if len(strings.Split(whyQuery, ":")) < 2 {
+				log.Fatalf("Dependency must be given as group:artifact or group:artifact:version, not %s", whyQuery)
+			}
+			for _, sub := range cmd.Commands() {
+				if sub.Name() != args[1] {
+					continue
+				}
+				if err := sub.ParseFlags(args[2:]); err == pflag.ErrHelp {
+					sub.Help()
+				} else if err != nil {
+					log.Fatalf("%s", err)
+				} else {
+					sub.Run(sub, sub.Flags().Args())
+				}
+				return
+			}
+			log.Fatalf("Unknown build tool: %s", args[1])
+		},
+	}
+	// The build tools are only reached through the query, so they are left out
+	// of the help for why
+	for _, sub := range []*cobra.Command{newMavenCmd(whyReporter), newGradleCmd(whyReporter), newParseCmd(whyReporter)} {
+		sub.Hidden = true
+		whyCmd.AddCommand(sub)
+	}
+	return &whyCmd
+}
+
+// Checks a dependency against a group:artifact[:version] query
+func matchesQuery(dep *models.Dependency, query string) bool {
+	split := strings.Split(query, ":")
+	if dep.GroupId != split[0] || dep.ArtifactId != split[1] {
+		return false
+	}
+	return len(split) < 3 || dep.Version == split[2]
+}
+
+// Finds every entry in the tree that matches the query. Each is returned as
+// the path to it, starting with the top-level dependency.
+func findPaths(analyzedDeps []AnalyzedDependency, query string) [][]*AnalyzedDependency {
+	var paths [][]*AnalyzedDependency
+	var walk func(deps []AnalyzedDependency)
+	walk = func(deps []AnalyzedDependency) {
+		for i := range deps {
+			entry := &deps[i]
+			if matchesQuery(entry.Dependency, query) {
+				var path []*AnalyzedDependency
+				for ptr := entry; ptr != nil; ptr = ptr.Parent {
+					path = append([]*AnalyzedDependency{ptr}, path...)
+				}
+				paths = append(paths, path)
+			}
+			walk(*entry.Children)
+		}
+	}
+	walk(analyzedDeps)
+	return paths
+}
+
+func printWhy(projects []models.Project) {
+	switch rootCtx.OutputFormat {
+	case OutputTree:
+		for i, project := range projects {
+			if i > 0 {
+				log.Info("")
+			}
+			printWhyTree(project)
+		}
+	case OutputJSON:
+		printWhyJSON(projects, os.Stdout)
+	default:
+		log.Fatalf("Output format %s is not supported by why", rootCtx.OutputFormat)
+	}
+}
+
+func printWhyTree(project models.Project) {
+	log.Infof("Project: %s (%s)", project.Name, project.Version)
+	paths := findPaths(calculateTotalSizes(project), whyQuery)
+	if len(paths) == 0 {
+		log.Infof("%s is not a dependency of this project", whyQuery)
+		return
+	}
+
+	if len(paths) == 1 {
+		log.Infof("1 path to %s:", whyQuery)
+	} else {
+		log.Infof("%d paths to %s:", len(paths), whyQuery)
+	}
+	for _, path := range paths {
+		log.Info("")
+		top := path[0]
+		totalColor := color.New(color.Reset)
+		if top.TotalSize > rootCtx.LargeDependencyThresholdBytes {
+			totalColor = color.New(color.BgRed)
+		}
+		log.Infof("%s%s Size[%s]", top.Dependency.Coordinates(), statusMarker(top.Dependency), totalColor.Sprintf("Total: %s", humanize.Bytes(top.TotalSize)))
+		for depth, entry := range path[1:] {
+			dep := entry.Dependency
+			indent := strings.Repeat("     ", depth)
+			if dep.Omitted() {
+				log.Infof("%s└── %s", indent,
+					color.New(color.Faint).Sprintf("(%s - omitted for conflict with %s)", dep.Coordinates(), dep.OmittedForConflictWith))
+			} else {
+				log.Infof("%s└── %s%s%s", indent, dep.Coordinates(), statusMarker(dep), mediationMarker(dep))
+			}
+		}
+	}
+}
+
+// Shows the paths to the dependency in each of the scopes given with --scopes
+func printWhyScopes(projects []scopedProject) {
+	switch rootCtx.OutputFormat {
+	case OutputTree:
+		for i, scoped := range projects {
+			if i > 0 {
+				log.Info("")
+			}
+			log.Infof("Scope: %s", scoped.Scope)
+			printWhyTree(scoped.Project)
+		}
+	case OutputJSON:
+		printWhyScopesJSON(projects, os.Stdout)
+	default:
+		log.Fatalf("Output format %s is not supported by why", rootCtx.OutputFormat)
+	}
+}
+
+func printWhyJSON(projects []models.Project, w io.Writer) {
+	result := []jsonWhy{}
+	for _, project := range projects {
+		result = append(result, toJSONWhy(project))
+	}
+	writeJSON(w, result)
+}
+
+func printWhyScopesJSON(projects []scopedProject, w io.Writer) {
+	result := []jsonWhy{}
+	for _, scoped := range projects {
+		why := toJSONWhy(scoped.Project)
+		why.Scope = scoped.Scope
+		result = append(result, why)
+	}
+	writeJSON(w, result)
+}
+
+func toJSONWhy(project models.Project) jsonWhy {
+	why := jsonWhy{
+		Name:    project.Name,
+		Version: project.Version,
+		Query:   whyQuery,
+		Paths:   []jsonWhyPath{},
+	}
+	for _, path := range findPaths(calculateTotalSizes(project), whyQuery) {
+		entry := jsonWhyPath{
+			TopLevelTotalSize: path[0].TotalSize,
+			Omitted:           path[len(path)-1].Dependency.Omitted(),
+		}
+		for _, step := range path {
+			entry.Path = append(entry.Path, step.Dependency.Coordinates())
+		}
+		why.Paths = append(why.Paths, entry)
+	}
+	return why
+}